@@ -42,7 +42,7 @@ interface Router {
 
     options(path: string, middleware: (namespace: never) => void, handler: (namespace: never) => void): Router;
 
-    route(namespace: never): boolean;
+    route(namespace: namespace): boolean;
 }
 
 interface Mail {
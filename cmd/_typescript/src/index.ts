@@ -0,0 +1,10 @@
+// noinspection JSUnusedGlobalSymbols
+const main = (namespace: namespace): void => {
+  const { router, response } = namespace.v1;
+
+  router.get('/', () => response.ok("Let's go!"));
+
+  if (!router.route(namespace)) {
+    response.notFound('Not found');
+  }
+};
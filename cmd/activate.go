@@ -21,6 +21,10 @@ Example:
 	Args: cobra.MaximumNArgs(0),
 	RunE: func(cmd *cobra.Command, args []string) error {
 		printActivateHeader(cmd.Parent().Version)
+		err := negotiate(cmd.Parent().Version)
+		if err != nil {
+			return err
+		}
 		return activateDeployment(activateDeployKey)
 	},
 }
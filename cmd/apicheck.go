@@ -0,0 +1,369 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/dop251/goja/ast"
+	"github.com/dop251/goja/file"
+	"github.com/dop251/goja/parser"
+	"github.com/evanw/esbuild/pkg/api"
+	"github.com/go-sourcemap/sourcemap"
+)
+
+// apiManifest maps each interface of the Cavemark runtime to its members and the type each member returns.
+type apiManifest map[string]map[string]string
+
+var (
+	runtimeAPI     apiManifest
+	runtimeAPIOnce sync.Once
+	runtimeAPIErr  error
+)
+
+var (
+	interfacePattern      = regexp.MustCompile(`^interface (\w+)\s*\{`)
+	methodPattern         = regexp.MustCompile(`^(\w+)\??\s*\(.*\)\s*:\s*(\w+)`)
+	propertyPattern       = regexp.MustCompile(`^(\w+)\??\s*:\s*(\w+)`)
+	identifierPattern     = regexp.MustCompile(`^[A-Za-z_$][\w$]*`)
+	nodeBuiltinsPattern   = `^(node:)?(assert|async_hooks|buffer|child_process|cluster|console|constants|crypto|dgram|diagnostics_channel|dns|domain|events|fs|http|http2|https|inspector|module|net|os|path|perf_hooks|process|punycode|querystring|readline|repl|stream|string_decoder|sys|timers|tls|trace_events|tty|url|util|v8|vm|wasi|worker_threads|zlib)(/.*)?$`
+	apiCheckSourcePattern = `\.(js|mjs|cjs|jsx|ts|tsx)$`
+)
+
+// loadRuntimeAPI reads the runtime API from the index.d.ts that ships with new projects,
+// so the declarations and the bundle-time check never drift apart.
+func loadRuntimeAPI() (apiManifest, error) {
+	runtimeAPIOnce.Do(func() {
+		var data []byte
+		data, runtimeAPIErr = initFS.ReadFile("_init/index.d.ts")
+		if runtimeAPIErr != nil {
+			return
+		}
+		runtimeAPI = parseDeclarations(data)
+	})
+	return runtimeAPI, runtimeAPIErr
+}
+
+func parseDeclarations(data []byte) apiManifest {
+	manifest := make(apiManifest)
+	var members map[string]string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if m := interfacePattern.FindStringSubmatch(line); m != nil {
+			members = make(map[string]string)
+			manifest[m[1]] = members
+			continue
+		}
+		if members == nil {
+			continue
+		}
+		if line == "}" {
+			members = nil
+			continue
+		}
+		if m := methodPattern.FindStringSubmatch(line); m != nil {
+			members[m[1]] = m[2]
+		} else if m := propertyPattern.FindStringSubmatch(line); m != nil {
+			members[m[1]] = m[2]
+		} else if m := identifierPattern.FindString(line); m != "" && strings.Contains(line, "(") {
+			// a method without a return type, such as queryValue(string, never)
+			members[m] = ""
+		}
+	}
+	return manifest
+}
+
+type apiProblem struct {
+	line   int
+	column int
+	text   string
+}
+
+// checkRuntimeAPIUsage parses the module at path and returns the uses of the namespace that
+// aren't part of the runtime API. The namespace is the first parameter of main and of the
+// handlers passed to the router. Member chains, such as response.status(200).ok(), variables
+// and destructured members are followed using the types from the manifest.
+func checkRuntimeAPIUsage(manifest apiManifest, path, src string) []apiProblem {
+	program, err := parseModule(path, src)
+	if err != nil {
+		// syntax errors are reported by esbuild itself
+		return nil
+	}
+	c := &apiChecker{
+		manifest:   manifest,
+		namespaces: make(map[string]bool),
+		bindings:   make(map[string]string),
+		problems:   make(map[file.Idx]string),
+	}
+	walkJS(program, c.visit)
+	offsets := make([]int, 0, len(c.problems))
+	for idx := range c.problems {
+		offsets = append(offsets, int(idx))
+	}
+	sort.Ints(offsets)
+	problems := make([]apiProblem, 0, len(offsets))
+	for _, offset := range offsets {
+		pos := program.File.Position(offset - program.File.Base())
+		problems = append(problems, apiProblem{pos.Line, pos.Column, c.problems[file.Idx(offset)]})
+	}
+	return problems
+}
+
+// parseModule transforms TypeScript, JSX and ES modules into a script the goja parser reads, and
+// maps the positions in the syntax tree back to src.
+func parseModule(path, src string) (*ast.Program, error) {
+	loader := api.LoaderJS
+	switch filepath.Ext(path) {
+	case ".jsx":
+		loader = api.LoaderJSX
+	case ".ts":
+		loader = api.LoaderTS
+	case ".tsx":
+		loader = api.LoaderTSX
+	}
+	result := api.Transform(src, api.TransformOptions{
+		Loader:     loader,
+		Format:     api.FormatCommonJS,
+		Target:     api.ES2020,
+		Sourcemap:  api.SourceMapExternal,
+		Sourcefile: path,
+	})
+	if len(result.Errors) > 0 {
+		return nil, errors.New(result.Errors[0].Text)
+	}
+	program, err := parser.ParseFile(nil, path, string(result.Code), 0, parser.WithDisableSourceMaps)
+	if err != nil {
+		return nil, err
+	}
+	sourceMap, err := sourcemap.Parse(path+".map", result.Map)
+	if err != nil {
+		return nil, err
+	}
+	program.File.SetSourceMap(sourceMap)
+	return program, nil
+}
+
+// apiChecker tracks the identifiers that hold the namespace or a runtime API interface while
+// walking a module. Identifiers aren't scoped, which is good enough for function code.
+type apiChecker struct {
+	manifest   apiManifest
+	namespaces map[string]bool
+	bindings   map[string]string
+	problems   map[file.Idx]string
+}
+
+func (c *apiChecker) visit(node ast.Node) {
+	switch n := node.(type) {
+	case *ast.FunctionDeclaration:
+		if n.Function.Name != nil && n.Function.Name.Name.String() == "main" {
+			c.addNamespaceParameter(n.Function)
+		}
+	case *ast.Binding:
+		if target, ok := n.Target.(*ast.Identifier); ok && target.Name.String() == "main" {
+			c.addNamespaceParameter(n.Initializer)
+			return
+		}
+		c.bind(n.Target, c.typeOf(n.Initializer))
+	case *ast.AssignExpression:
+		if target, ok := n.Left.(*ast.Identifier); ok && target.Name.String() == "main" {
+			c.addNamespaceParameter(n.Right)
+		}
+	case *ast.CallExpression:
+		// handlers and middleware get the namespace too
+		if callee, ok := n.Callee.(*ast.DotExpression); ok && c.typeOf(callee.Left) == "Router" {
+			for _, arg := range n.ArgumentList {
+				c.addNamespaceParameter(arg)
+			}
+		}
+	case *ast.DotExpression:
+		c.typeOf(n)
+	}
+}
+
+func (c *apiChecker) addNamespaceParameter(fn ast.Node) {
+	var params *ast.ParameterList
+	switch f := fn.(type) {
+	case *ast.FunctionLiteral:
+		params = f.ParameterList
+	case *ast.ArrowFunctionLiteral:
+		params = f.ParameterList
+	}
+	if params == nil || len(params.List) == 0 {
+		return
+	}
+	switch target := params.List[0].Target.(type) {
+	case *ast.Identifier:
+		c.namespaces[target.Name.String()] = true
+	case *ast.ObjectPattern:
+		c.bind(target, "namespace")
+	}
+}
+
+// bind records the type of the identifiers in a variable or destructuring pattern.
+func (c *apiChecker) bind(target ast.BindingTarget, iface string) {
+	if _, ok := c.manifest[iface]; !ok {
+		return
+	}
+	switch t := target.(type) {
+	case *ast.Identifier:
+		c.bindings[t.Name.String()] = iface
+	case *ast.ObjectPattern:
+		for _, prop := range t.Properties {
+			switch p := prop.(type) {
+			case *ast.PropertyShort:
+				c.bindings[p.Name.Name.String()] = c.member(iface, p.Name.Name.String(), p.Name.Idx)
+			case *ast.PropertyKeyed:
+				key, ok := p.Key.(*ast.StringLiteral)
+				if !ok || p.Computed {
+					continue
+				}
+				value, ok := p.Value.(ast.BindingTarget)
+				if !ok {
+					continue
+				}
+				c.bind(value, c.member(iface, key.Value.String(), key.Idx))
+			}
+		}
+	}
+}
+
+// typeOf returns the runtime API interface an expression evaluates to, or "" for anything else.
+// Members that aren't part of the interface are recorded as problems.
+func (c *apiChecker) typeOf(expr ast.Expression) string {
+	switch e := expr.(type) {
+	case *ast.Identifier:
+		if c.namespaces[e.Name.String()] {
+			return "namespace"
+		}
+		return c.bindings[e.Name.String()]
+	case *ast.DotExpression:
+		return c.member(c.typeOf(e.Left), e.Identifier.Name.String(), e.Identifier.Idx)
+	case *ast.CallExpression:
+		return c.typeOf(e.Callee)
+	case *ast.OptionalChain:
+		return c.typeOf(e.Expression)
+	case *ast.Optional:
+		return c.typeOf(e.Expression)
+	}
+	return ""
+}
+
+func (c *apiChecker) member(iface, name string, idx file.Idx) string {
+	members, ok := c.manifest[iface]
+	if !ok {
+		return ""
+	}
+	typ, ok := members[name]
+	if !ok {
+		c.problems[idx] = fmt.Sprintf("%q is not part of the Cavemark runtime API (%s)", name, iface)
+		return ""
+	}
+	return typ
+}
+
+var jsNodeType = reflect.TypeOf((*ast.Node)(nil)).Elem()
+
+// walkJS calls visit for every node of a syntax tree, before the nodes it contains.
+func walkJS(node ast.Node, visit func(ast.Node)) {
+	walkJSValue(reflect.ValueOf(node), visit)
+}
+
+func walkJSValue(v reflect.Value, visit func(ast.Node)) {
+	switch v.Kind() {
+	case reflect.Interface:
+		if !v.IsNil() {
+			walkJSValue(v.Elem(), visit)
+		}
+	case reflect.Ptr:
+		if v.IsNil() || v.Type().Elem().PkgPath() != jsNodeType.PkgPath() {
+			return
+		}
+		if v.Type().Implements(jsNodeType) {
+			visit(v.Interface().(ast.Node))
+		}
+		walkJSValue(v.Elem(), visit)
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			walkJSValue(v.Index(i), visit)
+		}
+	case reflect.Struct:
+		if v.Type().PkgPath() != jsNodeType.PkgPath() {
+			return
+		}
+		for i := 0; i < v.NumField(); i++ {
+			if v.Type().Field(i).IsExported() {
+				walkJSValue(v.Field(i), visit)
+			}
+		}
+	}
+}
+
+func apiCheckMessage(path, src string, problem apiProblem) api.Message {
+	lines := strings.Split(src, "\n")
+	lineText := ""
+	if problem.line >= 1 && problem.line <= len(lines) {
+		lineText = strings.TrimSuffix(lines[problem.line-1], "\r")
+	}
+	return api.Message{
+		Text: problem.text,
+		Location: &api.Location{
+			File:     path,
+			Line:     problem.line,
+			Column:   problem.column,
+			LineText: lineText,
+		},
+	}
+}
+
+// apiCheckPlugin reports uses of namespace.v1 that aren't part of the runtime API and imports of
+// Node built-ins, which the Cavemark runtime doesn't provide even though functions are bundled for node.
+func apiCheckPlugin(mode string) api.Plugin {
+	return api.Plugin{
+		Name: "cavemark-api-check",
+		Setup: func(build api.PluginBuild) {
+			report := func(msgs []api.Message) ([]api.Message, []api.Message) {
+				if mode == "error" {
+					return msgs, nil
+				}
+				return nil, msgs
+			}
+			build.OnResolve(api.OnResolveOptions{Filter: nodeBuiltinsPattern},
+				func(args api.OnResolveArgs) (api.OnResolveResult, error) {
+					errs, warnings := report([]api.Message{{
+						Text: fmt.Sprintf("%q is a Node built-in that the Cavemark runtime doesn't provide", args.Path),
+					}})
+					return api.OnResolveResult{Errors: errs, Warnings: warnings}, nil
+				})
+			build.OnLoad(api.OnLoadOptions{Filter: apiCheckSourcePattern, Namespace: "file"},
+				func(args api.OnLoadArgs) (api.OnLoadResult, error) {
+					if strings.Contains(args.Path, "node_modules") {
+						return api.OnLoadResult{}, nil
+					}
+					manifest, err := loadRuntimeAPI()
+					if err != nil {
+						return api.OnLoadResult{}, err
+					}
+					contents, err := ioutil.ReadFile(args.Path)
+					if err != nil {
+						return api.OnLoadResult{}, err
+					}
+					src := string(contents)
+					msgs := make([]api.Message, 0)
+					for _, problem := range checkRuntimeAPIUsage(manifest, args.Path, src) {
+						msgs = append(msgs, apiCheckMessage(args.Path, src, problem))
+					}
+					errs, warnings := report(msgs)
+					return api.OnLoadResult{Errors: errs, Warnings: warnings}, nil
+				})
+		},
+	}
+}
@@ -0,0 +1,79 @@
+package cmd
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCheckRuntimeAPIUsage(t *testing.T) {
+	manifest, err := loadRuntimeAPI()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		path string
+		src  string
+		want []apiProblem
+	}{
+		{
+			name: "valid chain",
+			path: "index.js",
+			src:  "function main(namespace) {\n  namespace.v1.response.status(200).ok('hi');\n}\n",
+			want: []apiProblem{},
+		},
+		{
+			name: "unknown member in chain",
+			path: "index.js",
+			src:  "function main(namespace) {\n  namespace.v1.response.status(200).okay('hi');\n}\n",
+			want: []apiProblem{{2, 36, `"okay" is not part of the Cavemark runtime API (Response)`}},
+		},
+		{
+			name: "v1 of another object",
+			path: "index.js",
+			src:  "const api = { v1: { users: [] } };\nfunction main(namespace) {\n  namespace.v1.response.ok(api.v1.users);\n}\n",
+			want: []apiProblem{},
+		},
+		{
+			name: "regex literal with a quote",
+			path: "index.js",
+			src:  "const quote = /\"/;\nfunction main(ns) {\n  ns.v1.respons.ok('hi');\n}\n",
+			want: []apiProblem{{3, 8, `"respons" is not part of the Cavemark runtime API (v1)`}},
+		},
+		{
+			name: "destructured members",
+			path: "index.js",
+			src:  "const main = (namespace) => {\n  const { router, respons } = namespace.v1;\n  router.gett('/', () => {});\n};\n",
+			want: []apiProblem{
+				{2, 18, `"respons" is not part of the Cavemark runtime API (v1)`},
+				{3, 9, `"gett" is not part of the Cavemark runtime API (Router)`},
+			},
+		},
+		{
+			name: "handler namespace",
+			path: "index.js",
+			src:  "function main(namespace) {\n  namespace.v1.router.get('/', (ns) => ns.v1.reponse.ok('hi'));\n}\n",
+			want: []apiProblem{{2, 45, `"reponse" is not part of the Cavemark runtime API (v1)`}},
+		},
+		{
+			name: "typescript",
+			path: "index.ts",
+			src:  "type Options = { verbose: boolean };\nexport const main = (namespace: namespace): void => {\n  const response: Response = namespace.v1.response;\n  response.sendd('hi');\n};\n",
+			want: []apiProblem{{4, 11, `"sendd" is not part of the Cavemark runtime API (Response)`}},
+		},
+		{
+			name: "syntax error",
+			path: "index.js",
+			src:  "function main(namespace) {\n",
+			want: nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := checkRuntimeAPIUsage(manifest, tt.path, tt.src)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("checkRuntimeAPIUsage() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,192 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/spf13/pflag"
+)
+
+var (
+	batchUpload   bool
+	batchMinFiles string
+)
+
+const (
+	cavemarkBatch         = "CAVEMARK_BATCH"
+	cavemarkBatchMinFiles = "CAVEMARK_BATCH_MIN_FILES"
+)
+
+func batchURL(deployKey string) string {
+	return apiURL("/deploy/%s/batch", deployKey)
+}
+
+// batchSupported reports whether the files of a deployment are uploaded as one archive, which
+// needs a server that advertises the batch feature and at least --batch-min-files files.
+func batchSupported() (bool, error) {
+	if !batchUpload {
+		return false, nil
+	}
+	if !server.supports(featureBatch) {
+		p("batch", "the server doesn't support batch uploads, uploading files one by one\n")
+		return false, nil
+	}
+	minFiles, err := parseBatchMinFiles()
+	if err != nil {
+		return false, err
+	}
+	count, err := deployFileCount()
+	if err != nil {
+		return false, err
+	}
+	if count < minFiles {
+		p("batch", "%d files are fewer than --batch-min-files, uploading files one by one\n", count)
+		return false, nil
+	}
+	return true, nil
+}
+
+func parseBatchMinFiles() (int, error) {
+	minFiles, err := strconv.Atoi(batchMinFiles)
+	if err != nil || minFiles < 0 {
+		return 0, fmt.Errorf("batch min files (%s) must be 0 or more", batchMinFiles)
+	}
+	return minFiles, nil
+}
+
+// deployFileCount returns the number of resource and static files of the deployment.
+func deployFileCount() (int, error) {
+	if deployArtifact != "" {
+		r, err := openArtifact(deployArtifact)
+		if err != nil {
+			return 0, err
+		}
+		defer func() { _ = r.Close() }()
+		count := 0
+		for _, f := range r.manifest.Files {
+			if f.Kind == "resource" || f.Kind == "static" {
+				count++
+			}
+		}
+		return count, nil
+	}
+	count := 0
+	for _, dir := range []string{resourceDir, staticDir} {
+		if dir == "" {
+			continue
+		}
+		_, err := os.Lstat(dir)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return 0, err
+		}
+		files, err := globAll(dir)
+		if err != nil {
+			return 0, fmt.Errorf("error globbing files: %w", err)
+		}
+		count += len(files)
+	}
+	return count, nil
+}
+
+// deployBatch uploads the function bundle, resource files and static files as one zip archive
+// in the format of "cavemark build", with the precompressed variants of static files added. The
+// archive is written to a temporary file first, so it can be uploaded in resumable chunks.
+func deployBatch(deployKey string) error {
+	tmp, err := ioutil.TempFile("", "cavemark-batch-*.zip")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		// closing again after a successful close only returns an error, which is ignored
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+	}()
+
+	w := newArtifactWriter(tmp, rootCmd.Version)
+	if precompress {
+		w.variants = newPrecompressStats()
+	}
+	if deployArtifact != "" {
+		err = addArtifactArchive(w)
+	} else {
+		err = addArtifactFiles(w)
+	}
+	if err != nil {
+		return err
+	}
+	err = w.close()
+	if err != nil {
+		return err
+	}
+	err = tmp.Close()
+	if err != nil {
+		return err
+	}
+
+	for _, f := range w.manifest.Files {
+		if f.Kind == "resource" || f.Kind == "static" {
+			err = server.checkFileSize(f.Kind+"/"+f.Path, f.Size)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	body, err := fileBody(tmp.Name())
+	if err != nil {
+		return err
+	}
+	p("batch", "deploying %d files in one archive (%s)", len(w.manifest.Files), formatByteSize(body.size))
+	status, err := upload(batchURL(deployKey), "application/zip", nil, body)
+	if err != nil {
+		p("", " [ERROR]\n")
+		return fmt.Errorf("error deploying batch: %w", err)
+	}
+	if status != http.StatusNoContent {
+		p("", " [%d]\n", status)
+		return fmt.Errorf("failed to deploy batch")
+	}
+	p("", " [OK]\n")
+	if w.variants != nil {
+		w.variants.print()
+	}
+	p("batch", "successfully deployed\n")
+	return nil
+}
+
+// addArtifactArchive copies the files of the --artifact archive into w.
+func addArtifactArchive(w *artifactWriter) error {
+	r, err := openArtifact(deployArtifact)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = r.Close() }()
+	p("artifact", "deploying artifact %s built by cli %s at %s\n", deployArtifact, r.manifest.Build.CLIVersion, r.manifest.Build.Timestamp.Format(time.RFC1123))
+	w.manifest.Build = r.manifest.Build
+	for _, f := range r.manifest.Files {
+		body, err := r.body(f)
+		if err != nil {
+			return err
+		}
+		err = w.addBody(f.Kind, f.Path, f.ContentType, f.Headers, body)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func addBatchFlags(flags *pflag.FlagSet) {
+	flags.BoolVarP(&batchUpload, "batch", "", true, fmt.Sprintf("upload all files in one archive, written to a temporary file first, when the server supports it [%s]", cavemarkBatch))
+	flags.StringVarP(&batchMinFiles, "batch-min-files", "", "", fmt.Sprintf("the number of resource and static files from which --batch uploads them in one archive [%s]", cavemarkBatchMinFiles))
+	batchUpload = resolveBoolFlag(batchUpload, cavemarkBatch)
+	batchMinFiles = resolveStringFlag(batchMinFiles, cavemarkBatchMinFiles, "10")
+}
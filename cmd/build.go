@@ -0,0 +1,521 @@
+package cmd
+
+import (
+	"archive/zip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	buildOutput    string
+	deployArtifact string
+)
+
+const (
+	cavemarkArtifact = "CAVEMARK_ARTIFACT"
+)
+
+// artifactFormatVersion is incremented whenever the layout of the artifact changes in a way
+// that older versions of the cli can't deploy.
+const artifactFormatVersion = 1
+
+const artifactManifestName = "manifest.json"
+
+var buildCmd = &cobra.Command{
+	Use:   "build",
+	Short: "build a deployable artifact",
+	Long: `Builds a deployable artifact without deploying it.
+
+The artifact is a zip archive that contains the function bundle, resource files, static files and
+a manifest with the hash and content type of every file. Deploy the artifact with
+"cavemark deploy --artifact" to upload exactly the same files to every environment.
+
+Secrets are not included in the artifact. They are read from the environment when the artifact
+is deployed.
+
+Examples:
+  # builds the project in the current directory into dist.cvm
+  cavemark build -o dist.cvm
+
+  # deploys the artifact without rebuilding
+  cavemark deploy --artifact dist.cvm`,
+	Args: cobra.MaximumNArgs(0),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		version := cmd.Parent().Version
+		p("cavemark", "version %s\n", version)
+		if showIgnored {
+			err := printIgnoredFiles()
+			if err != nil {
+				return err
+			}
+		}
+		p("build", "building artifact %s\n", buildOutput)
+		err := buildArtifact(buildOutput, version)
+		if err != nil {
+			p("error", "%s\n", err)
+			return err
+		}
+		p("build", "successfully built %s\n", buildOutput)
+		return nil
+	},
+}
+
+type artifactManifest struct {
+	FormatVersion int            `json:"formatVersion"`
+	Build         artifactBuild  `json:"build"`
+	Files         []artifactFile `json:"files"`
+}
+
+type artifactBuild struct {
+	CLIVersion string    `json:"cliVersion"`
+	Timestamp  time.Time `json:"timestamp"`
+	Minify     bool      `json:"minify"`
+	Sourcemap  string    `json:"sourcemap"`
+	Target     string    `json:"target"`
+}
+
+type artifactFile struct {
+	Kind        string            `json:"kind"`
+	Path        string            `json:"path"`
+	ContentType string            `json:"contentType"`
+	Size        int64             `json:"size"`
+	SHA256      string            `json:"sha256"`
+	Headers     map[string]string `json:"headers,omitempty"`
+	// ContentEncoding is set for the precompressed variants of static files in a batch upload
+	ContentEncoding string `json:"contentEncoding,omitempty"`
+}
+
+func (f artifactFile) archivePath() string {
+	return f.Kind + "/" + f.Path
+}
+
+type artifactWriter struct {
+	zw          *zip.Writer
+	manifest    artifactManifest
+	headerRules headerRules
+	// variants adds the precompressed variants of static files when set, see deployBatch
+	variants *precompressStats
+}
+
+func (w *artifactWriter) add(kind, filePath, contentType string, contents []byte) error {
+	return w.addWithHeaders(kind, filePath, contentType, nil, contents)
+}
+
+// addWithHeaders adds a file with the headers it is deployed with.
+func (w *artifactWriter) addWithHeaders(kind, filePath, contentType string, headers map[string]string, contents []byte) error {
+	return w.addBody(kind, filePath, contentType, headers, bytesBody(contents))
+}
+
+// addBody streams a file into the archive and hashes it on the way.
+func (w *artifactWriter) addBody(kind, filePath, contentType string, headers map[string]string, body uploadBody) error {
+	err := w.addEncoded(artifactFile{Kind: kind, Path: filePath, ContentType: contentType, Headers: headers}, body)
+	if err != nil || kind != "static" || w.variants == nil {
+		return err
+	}
+	variants, err := precompressVariants(contentType, body)
+	defer removeVariants(variants)
+	if err != nil {
+		return err
+	}
+	if len(variants) > 0 {
+		w.variants.files++
+	}
+	for _, v := range variants {
+		w.variants.saved[v.encoding] += body.size - v.body.size
+		err = w.addEncoded(artifactFile{Kind: kind, Path: filePath + v.ext, ContentType: contentType, Headers: headers, ContentEncoding: v.encoding}, v.body)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *artifactWriter) addEncoded(file artifactFile, body uploadBody) error {
+	rc, err := body.open(0)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = rc.Close() }()
+	out, err := w.zw.Create(file.archivePath())
+	if err != nil {
+		return err
+	}
+	hash := sha256.New()
+	file.Size, err = io.Copy(io.MultiWriter(out, hash), rc)
+	if err != nil {
+		return err
+	}
+	file.SHA256 = hex.EncodeToString(hash.Sum(nil))
+	w.manifest.Files = append(w.manifest.Files, file)
+	return nil
+}
+
+// close writes the manifest and finishes the archive.
+func (w *artifactWriter) close() error {
+	manifest, err := json.MarshalIndent(w.manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	out, err := w.zw.Create(artifactManifestName)
+	if err != nil {
+		return err
+	}
+	_, err = out.Write(manifest)
+	if err != nil {
+		return err
+	}
+	return w.zw.Close()
+}
+
+func buildArtifact(output, version string) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(output), ".cavemark-build-*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		// closing again after a successful close only returns an error, which is ignored
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+	}()
+
+	w := newArtifactWriter(tmp, version)
+	err = addArtifactFiles(w)
+	if err != nil {
+		return err
+	}
+	err = w.close()
+	if err != nil {
+		return err
+	}
+	err = tmp.Close()
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), output)
+}
+
+func newArtifactWriter(out io.Writer, version string) *artifactWriter {
+	return &artifactWriter{
+		zw: zip.NewWriter(out),
+		manifest: artifactManifest{
+			FormatVersion: artifactFormatVersion,
+			Build: artifactBuild{
+				CLIVersion: version,
+				Timestamp:  time.Now().UTC(),
+				Minify:     bundleMinify,
+				Sourcemap:  bundleSourcemap,
+				Target:     bundleTarget,
+			},
+			Files: make([]artifactFile, 0),
+		},
+	}
+}
+
+// addArtifactFiles adds the function bundle, resource files and static files of the project.
+func addArtifactFiles(w *artifactWriter) error {
+	err := addArtifactFunction(w)
+	if err != nil {
+		return err
+	}
+	err = validateTemplates(resourceDir, defaultResourceDir)
+	if err != nil {
+		return err
+	}
+	fp, err := fingerprintAssets()
+	if err != nil {
+		return err
+	}
+	w.headerRules, err = loadHeaderRules()
+	if err != nil {
+		return err
+	}
+	err = addArtifactDir(w, "resource", resourceDir, defaultResourceDir, fp)
+	if err != nil {
+		return err
+	}
+	if fp != nil {
+		manifest, err := fp.assetManifest()
+		if err != nil {
+			return err
+		}
+		p("resources", "adding file %s (application/json)\n", assetManifestName)
+		err = w.add("resource", assetManifestName, "application/json", manifest)
+		if err != nil {
+			return err
+		}
+	}
+	err = addArtifactDir(w, "static", staticDir, defaultStaticDir, fp)
+	if err != nil {
+		return err
+	}
+	err = addArtifactRedirects(w)
+	if err != nil {
+		return err
+	}
+	err = addArtifactOpenAPI(w)
+	if err != nil {
+		return err
+	}
+	if len(w.manifest.Files) == 0 {
+		return errors.New("no index.js, index.mjs, index.ts, index.tsx, resource or static files to build")
+	}
+	return nil
+}
+
+func addArtifactFunction(w *artifactWriter) error {
+	indexExists, err := indexFunctionExists()
+	if err != nil {
+		return err
+	}
+	if !indexExists {
+		return nil
+	}
+	if typecheck {
+		err = runTypecheck()
+		if err != nil {
+			return err
+		}
+	}
+	p("functions", "creating bundle")
+	result, err := bundle()
+	if err != nil {
+		return err
+	}
+	p("", " [OK]\n")
+	err = checkBundleSize(result)
+	if err != nil {
+		return err
+	}
+	err = w.add("function", "index.js", "text/plain", result.code)
+	if err != nil {
+		return err
+	}
+	if result.sourceMap != nil {
+		return w.add("sourcemap", "index.js.map", "application/json", result.sourceMap)
+	}
+	return nil
+}
+
+func addArtifactDir(w *artifactWriter, kind, dir, defaultDir string, fp *assetFingerprints) error {
+	if dir == "" {
+		return nil
+	}
+	_, err := os.Lstat(dir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) && dir == defaultDir {
+			return nil
+		}
+		return err
+	}
+	files, err := globAll(dir)
+	if err != nil {
+		return fmt.Errorf("error globbing files: %w", err)
+	}
+	for _, f := range files {
+		body, err := fileBody(f)
+		if err != nil {
+			return err
+		}
+		filePath := filepath.ToSlash(removeDir(f, dir))
+		contentType, err := sniffContentType(f)
+		if err != nil {
+			return err
+		}
+		var headers map[string]string
+		if kind == "static" {
+			headers = w.headerRules.match(filePath)
+		}
+		filePath, body, err = fp.apply(kind, filePath, body)
+		if err != nil {
+			return err
+		}
+		p(kind+"s", "adding file %s (%s)\n", f, contentType)
+		err = w.addBody(kind, filePath, contentType, headers, body)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type artifactReader struct {
+	zr       *zip.ReadCloser
+	files    map[string]*zip.File
+	manifest artifactManifest
+}
+
+func openArtifact(name string) (*artifactReader, error) {
+	zr, err := zip.OpenReader(name)
+	if err != nil {
+		return nil, fmt.Errorf("error opening artifact (%s): %w", name, err)
+	}
+	r := &artifactReader{zr: zr, files: make(map[string]*zip.File)}
+	for _, f := range zr.File {
+		r.files[f.Name] = f
+	}
+	m, ok := r.files[artifactManifestName]
+	if !ok {
+		_ = zr.Close()
+		return nil, fmt.Errorf("artifact (%s) has no manifest", name)
+	}
+	rc, err := m.Open()
+	if err != nil {
+		_ = zr.Close()
+		return nil, err
+	}
+	defer func() { _ = rc.Close() }()
+	err = json.NewDecoder(rc).Decode(&r.manifest)
+	if err != nil {
+		_ = zr.Close()
+		return nil, fmt.Errorf("error reading artifact manifest: %w", err)
+	}
+	if r.manifest.FormatVersion > artifactFormatVersion {
+		_ = zr.Close()
+		return nil, fmt.Errorf("artifact format version %d is not supported by this version of the cli, please upgrade", r.manifest.FormatVersion)
+	}
+	for _, f := range r.manifest.Files {
+		if _, ok := r.files[f.archivePath()]; !ok {
+			_ = zr.Close()
+			return nil, fmt.Errorf("artifact is missing file (%s)", f.archivePath())
+		}
+	}
+	return r, nil
+}
+
+// read returns the contents of a file in the artifact after checking it against the manifest hash.
+func (r *artifactReader) read(file artifactFile) ([]byte, error) {
+	rc, err := r.files[file.archivePath()].Open()
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rc.Close() }()
+	contents, err := io.ReadAll(rc)
+	if err != nil {
+		return nil, err
+	}
+	hash := sha256.Sum256(contents)
+	if hex.EncodeToString(hash[:]) != file.SHA256 {
+		return nil, fmt.Errorf("artifact file (%s) does not match its hash", file.archivePath())
+	}
+	return contents, nil
+}
+
+// body verifies the hash of an artifact file and returns it as an upload body that is streamed
+// from the archive.
+func (r *artifactReader) body(file artifactFile) (uploadBody, error) {
+	zf := r.files[file.archivePath()]
+	rc, err := zf.Open()
+	if err != nil {
+		return uploadBody{}, err
+	}
+	hash := sha256.New()
+	_, err = io.Copy(hash, rc)
+	_ = rc.Close()
+	if err != nil {
+		return uploadBody{}, err
+	}
+	if hex.EncodeToString(hash.Sum(nil)) != file.SHA256 {
+		return uploadBody{}, fmt.Errorf("artifact file (%s) does not match its hash", file.archivePath())
+	}
+	return uploadBody{
+		open: func(offset int64) (io.ReadCloser, error) {
+			rc, err := zf.Open()
+			if err != nil {
+				return nil, err
+			}
+			// entries of a zip archive can't seek, so the bytes before offset are skipped
+			_, err = io.CopyN(ioutil.Discard, rc, offset)
+			if err != nil {
+				_ = rc.Close()
+				return nil, err
+			}
+			return rc, nil
+		},
+		size: int64(zf.UncompressedSize64),
+		id:   file.SHA256,
+	}, nil
+}
+
+func (r *artifactReader) Close() error {
+	return r.zr.Close()
+}
+
+func deployArtifactFiles(deployKey string) error {
+	r, err := openArtifact(deployArtifact)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = r.Close() }()
+	p("artifact", "deploying artifact %s built by cli %s at %s\n", deployArtifact, r.manifest.Build.CLIVersion, r.manifest.Build.Timestamp.Format(time.RFC1123))
+
+	function := &bundleResult{}
+	for _, f := range r.manifest.Files {
+		switch f.Kind {
+		case "function":
+			function.code, err = r.read(f)
+		case "sourcemap":
+			function.sourceMap, err = r.read(f)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if function.code != nil {
+		err = deployBundle(deployKey, function)
+		if err != nil {
+			return err
+		}
+	}
+
+	stats := newPrecompressStats()
+	for _, kind := range []string{"resource", "static"} {
+		for _, f := range r.manifest.Files {
+			if f.Kind != kind {
+				continue
+			}
+			p(kind+"s", "deploying file %s (%s)", f.Path, f.ContentType)
+			body, err := r.body(f)
+			if err != nil {
+				return err
+			}
+			if kind == "static" {
+				err = deployStaticFile(deployKey, f.Path, f.ContentType, f.Headers, body, stats)
+			} else {
+				err = deployFile(deployKey, kind, f.Path, f.ContentType, body)
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+	stats.print()
+	p("artifact", "successfully deployed\n")
+	return nil
+}
+
+func init() {
+	buildCmd.Flags().StringVarP(&buildOutput, "output", "o", "dist.cvm", "the artifact file to write")
+	buildCmd.Flags().BoolVarP(&typecheck, "typecheck", "", false, fmt.Sprintf("type check TypeScript functions before bundling [%s]", cavemarkTypecheck))
+	addProjectDirFlags(buildCmd.Flags())
+	addBundleFlags(buildCmd.Flags())
+	addOpenAPIFlags(buildCmd.Flags())
+	addContentTypeFlags(buildCmd.Flags())
+	addFingerprintFlags(buildCmd.Flags())
+	addHeadersFlags(buildCmd.Flags())
+	addRedirectsFlags(buildCmd.Flags())
+	addShowIgnoredFlag(buildCmd.Flags())
+	addValidateTemplateFlag(buildCmd.Flags())
+	rootCmd.AddCommand(buildCmd)
+
+	typecheck = resolveBoolFlag(typecheck, cavemarkTypecheck)
+}
@@ -0,0 +1,474 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/evanw/esbuild/pkg/api"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+var (
+	bundleAPICheck  string
+	bundleAnalyze   bool
+	bundleMaxSize   string
+	bundleMinify    bool
+	bundleSourcemap string
+	bundleTarget    string
+	bundleDefines   []string
+	bundleExternals []string
+	bundleLoaders   []string
+)
+
+const (
+	cavemarkAPICheck      = "CAVEMARK_API_CHECK"
+	cavemarkMaxBundleSize = "CAVEMARK_MAX_BUNDLE_SIZE"
+	cavemarkMinify        = "CAVEMARK_MINIFY"
+	cavemarkSourcemap     = "CAVEMARK_SOURCEMAP"
+	cavemarkTarget        = "CAVEMARK_TARGET"
+	cavemarkDefine        = "CAVEMARK_DEFINE"
+	cavemarkExternal      = "CAVEMARK_EXTERNAL"
+	cavemarkLoader        = "CAVEMARK_LOADER"
+)
+
+var defaultBundleLoaders = []string{".json=json", ".txt=text", ".html=text"}
+
+var bundleCmd = &cobra.Command{
+	Use:   "bundle",
+	Short: "bundle functions without deploying",
+	Long: `Bundles the functions in the function directory without deploying them.
+
+Example:
+  # bundles the functions in "src" and prints the bytes each module contributes
+  cavemark bundle --analyze
+
+  # fails when the bundle is larger than 256KB
+  cavemark bundle --max-bundle-size 256KB`,
+	Args: cobra.MaximumNArgs(0),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		p("cavemark", "version %s\n", cmd.Parent().Version)
+		p("bundle", "bundling functions in '%s'\n", funcDir)
+		result, err := bundle()
+		if err != nil {
+			return err
+		}
+		if bundleAnalyze {
+			err = printBundleAnalysis(result.metafile)
+			if err != nil {
+				return err
+			}
+		}
+		err = checkBundleSize(result)
+		if err != nil {
+			return err
+		}
+		p("bundle", "bundle size is %s\n", formatByteSize(int64(len(result.code))))
+		return nil
+	},
+}
+
+type bundleResult struct {
+	code      []byte
+	sourceMap []byte
+	metafile  string
+}
+
+func bundle() (*bundleResult, error) {
+	entry, err := entryPoint()
+	if err != nil {
+		return nil, err
+	}
+	if entry == "" {
+		return nil, fmt.Errorf("no index entry point found in '%s'", funcDir)
+	}
+	return bundleEntry(entry)
+}
+
+// bundleEntry bundles entry with the bundle flags, the same way the function is bundled.
+func bundleEntry(entry string) (*bundleResult, error) {
+	options, err := bundleOptions(entry)
+	if err != nil {
+		return nil, err
+	}
+	return buildBundle(options)
+}
+
+func buildBundle(options api.BuildOptions) (*bundleResult, error) {
+	result := api.Build(options)
+	if len(result.Errors) > 0 {
+		for _, err := range result.Errors {
+			fmt.Println(err.Text)
+		}
+		return nil, errors.New("error while bundling")
+	}
+	br := &bundleResult{metafile: result.Metafile}
+	for _, f := range result.OutputFiles {
+		if strings.HasSuffix(f.Path, ".map") {
+			br.sourceMap = f.Contents
+		} else {
+			br.code = f.Contents
+		}
+	}
+	return br, nil
+}
+
+// esmEntryGlobal is the global that holds the exports of an index.mjs entry point.
+const esmEntryGlobal = "__cavemarkEntry"
+
+func bundleOptions(entry string) (api.BuildOptions, error) {
+	sourcemap, err := parseSourcemap(bundleSourcemap)
+	if err != nil {
+		return api.BuildOptions{}, err
+	}
+	target, err := parseTarget(bundleTarget)
+	if err != nil {
+		return api.BuildOptions{}, err
+	}
+	defines, err := parsePairs(bundleDefines, "define")
+	if err != nil {
+		return api.BuildOptions{}, err
+	}
+	loaders, err := parseLoaders(append(defaultBundleLoaders, bundleLoaders...))
+	if err != nil {
+		return api.BuildOptions{}, err
+	}
+	plugins := make([]api.Plugin, 0)
+	switch bundleAPICheck {
+	case "off":
+	case "warn", "error":
+		plugins = append(plugins, apiCheckPlugin(bundleAPICheck))
+	default:
+		return api.BuildOptions{}, fmt.Errorf("api check (%s) not supported", bundleAPICheck)
+	}
+	options := api.BuildOptions{
+		Bundle:           true,
+		MinifySyntax:     bundleMinify,
+		MinifyWhitespace: bundleMinify,
+		Color:            api.ColorNever,
+		TreeShaking:      api.TreeShakingFalse,
+		EntryPoints:      []string{entry},
+		Outfile:          "index.js",
+		Platform:         api.PlatformNode,
+		LogLevel:         api.LogLevelInfo,
+		Sourcemap:        sourcemap,
+		Target:           target,
+		Define:           defines,
+		External:         bundleExternals,
+		Loader:           loaders,
+		Metafile:         true,
+		Plugins:          plugins,
+	}
+	if filepath.Ext(entry) == ".mjs" {
+		// an ES module exports main instead of declaring it globally, so the module is bundled into
+		// a global object and its main export is assigned to the global main the runtime calls
+		options.Format = api.FormatIIFE
+		options.GlobalName = esmEntryGlobal
+		options.Footer = map[string]string{"js": fmt.Sprintf("var main = %s.main;", esmEntryGlobal)}
+	}
+	return options, nil
+}
+
+func parseSourcemap(value string) (api.SourceMap, error) {
+	switch value {
+	case "", "none":
+		return api.SourceMapNone, nil
+	case "inline":
+		return api.SourceMapInline, nil
+	case "external":
+		return api.SourceMapExternal, nil
+	default:
+		return api.SourceMapNone, fmt.Errorf("sourcemap (%s) not supported", value)
+	}
+}
+
+func parseTarget(value string) (api.Target, error) {
+	switch strings.ToLower(value) {
+	case "", "esnext":
+		return api.ESNext, nil
+	case "es5":
+		return api.ES5, nil
+	case "es2015", "es6":
+		return api.ES2015, nil
+	case "es2016":
+		return api.ES2016, nil
+	case "es2017":
+		return api.ES2017, nil
+	case "es2018":
+		return api.ES2018, nil
+	case "es2019":
+		return api.ES2019, nil
+	case "es2020":
+		return api.ES2020, nil
+	case "es2021":
+		return api.ES2021, nil
+	case "es2022":
+		// esbuild doesn't have an ES2022 target yet, the features it adds are only lowered
+		// for es2021 and older, so es2022 output is the same as esnext
+		return api.ESNext, nil
+	default:
+		return api.DefaultTarget, fmt.Errorf("target (%s) not supported", value)
+	}
+}
+
+func parseLoaders(values []string) (map[string]api.Loader, error) {
+	pairs, err := parsePairs(values, "loader")
+	if err != nil {
+		return nil, err
+	}
+	loaders := make(map[string]api.Loader)
+	for ext, name := range pairs {
+		if !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
+		}
+		switch name {
+		case "js":
+			loaders[ext] = api.LoaderJS
+		case "jsx":
+			loaders[ext] = api.LoaderJSX
+		case "ts":
+			loaders[ext] = api.LoaderTS
+		case "tsx":
+			loaders[ext] = api.LoaderTSX
+		case "json":
+			loaders[ext] = api.LoaderJSON
+		case "text":
+			loaders[ext] = api.LoaderText
+		case "base64":
+			loaders[ext] = api.LoaderBase64
+		case "dataurl":
+			loaders[ext] = api.LoaderDataURL
+		case "binary":
+			loaders[ext] = api.LoaderBinary
+		default:
+			return nil, fmt.Errorf("loader (%s) not supported for %s", name, ext)
+		}
+	}
+	return loaders, nil
+}
+
+func parsePairs(values []string, name string) (map[string]string, error) {
+	pairs := make(map[string]string)
+	for _, v := range values {
+		pair := strings.SplitN(v, "=", 2)
+		if len(pair) != 2 || pair[0] == "" {
+			return nil, fmt.Errorf("%s (%s) must be in the form key=value", name, v)
+		}
+		pairs[pair[0]] = pair[1]
+	}
+	return pairs, nil
+}
+
+type bundleMetafile struct {
+	Outputs map[string]struct {
+		Bytes  int64 `json:"bytes"`
+		Inputs map[string]struct {
+			BytesInOutput int64 `json:"bytesInOutput"`
+		} `json:"inputs"`
+	} `json:"outputs"`
+}
+
+type moduleSize struct {
+	path  string
+	bytes int64
+}
+
+func printBundleAnalysis(metafile string) error {
+	meta := bundleMetafile{}
+	err := json.Unmarshal([]byte(metafile), &meta)
+	if err != nil {
+		return fmt.Errorf("error reading metafile: %w", err)
+	}
+	for output, o := range meta.Outputs {
+		if strings.HasSuffix(output, ".map") {
+			continue
+		}
+		modules := make([]moduleSize, 0, len(o.Inputs))
+		for input, i := range o.Inputs {
+			modules = append(modules, moduleSize{input, i.BytesInOutput})
+		}
+		sort.Slice(modules, func(i, j int) bool {
+			if modules[i].bytes == modules[j].bytes {
+				return modules[i].path < modules[j].path
+			}
+			return modules[i].bytes > modules[j].bytes
+		})
+		fmt.Println("Size      \tPercent\tModule")
+		fmt.Println("----------\t-------\t------")
+		for _, m := range modules {
+			percent := 0.0
+			if o.Bytes > 0 {
+				percent = float64(m.bytes) * 100 / float64(o.Bytes)
+			}
+			fmt.Printf("%10s\t%6.1f%%\t%s\n", formatByteSize(m.bytes), percent, m.path)
+		}
+		printDuplicatePackages(modules)
+	}
+	return nil
+}
+
+// printDuplicatePackages reports packages bundled from more than one node_modules directory,
+// which usually means several versions of the same package ended up in the bundle.
+func printDuplicatePackages(modules []moduleSize) {
+	packageRoots := make(map[string]map[string]bool)
+	for _, m := range modules {
+		name, root := packageOf(m.path)
+		if name == "" {
+			continue
+		}
+		if packageRoots[name] == nil {
+			packageRoots[name] = make(map[string]bool)
+		}
+		packageRoots[name][root] = true
+	}
+	names := make([]string, 0)
+	for name, roots := range packageRoots {
+		if len(roots) > 1 {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return
+	}
+	sort.Strings(names)
+	fmt.Println()
+	for _, name := range names {
+		p("duplicate", "%s is bundled %d times\n", name, len(packageRoots[name]))
+		roots := make([]string, 0)
+		for root := range packageRoots[name] {
+			roots = append(roots, root)
+		}
+		sort.Strings(roots)
+		for _, root := range roots {
+			p("duplicate", "  %s\n", root)
+		}
+	}
+}
+
+// packageOf returns the name and root directory of the innermost node_modules package that contains the module.
+func packageOf(modulePath string) (string, string) {
+	const nodeModules = "node_modules/"
+	i := strings.LastIndex(modulePath, nodeModules)
+	if i < 0 {
+		return "", ""
+	}
+	parts := strings.Split(modulePath[i+len(nodeModules):], "/")
+	name := parts[0]
+	if strings.HasPrefix(name, "@") && len(parts) > 1 {
+		name = name + "/" + parts[1]
+	}
+	return name, modulePath[:i+len(nodeModules)] + name
+}
+
+func checkBundleSize(result *bundleResult) error {
+	if bundleMaxSize == "" {
+		return nil
+	}
+	maxSize, err := parseByteSize(bundleMaxSize)
+	if err != nil {
+		return err
+	}
+	size := int64(len(result.code))
+	if size > maxSize {
+		return fmt.Errorf("bundle size (%s) exceeds the maximum bundle size (%s)", formatByteSize(size), formatByteSize(maxSize))
+	}
+	return nil
+}
+
+func parseByteSize(value string) (int64, error) {
+	s := strings.ToUpper(strings.TrimSpace(value))
+	multiplier := int64(1)
+	switch {
+	case strings.HasSuffix(s, "MB"):
+		multiplier = 1024 * 1024
+		s = strings.TrimSuffix(s, "MB")
+	case strings.HasSuffix(s, "KB"):
+		multiplier = 1024
+		s = strings.TrimSuffix(s, "KB")
+	case strings.HasSuffix(s, "B"):
+		s = strings.TrimSuffix(s, "B")
+	}
+	n, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("size (%s) is not valid, use a value such as 500KB or 2MB", value)
+	}
+	return n * multiplier, nil
+}
+
+func formatByteSize(n int64) string {
+	switch {
+	case n >= 1024*1024:
+		return fmt.Sprintf("%.1fMB", float64(n)/(1024*1024))
+	case n >= 1024:
+		return fmt.Sprintf("%.1fKB", float64(n)/1024)
+	default:
+		return fmt.Sprintf("%dB", n)
+	}
+}
+
+func addBundleFlags(flags *pflag.FlagSet) {
+	flags.StringVarP(&bundleAPICheck, "api-check", "", "", fmt.Sprintf("check uses of namespace.v1 and Node built-ins against the runtime API (off, warn, error) [%s]", cavemarkAPICheck))
+	flags.StringVarP(&bundleMaxSize, "max-bundle-size", "", "", fmt.Sprintf("fail when the function bundle is larger than this size, e.g. 500KB [%s]", cavemarkMaxBundleSize))
+	flags.BoolVarP(&bundleMinify, "minify", "", true, fmt.Sprintf("minify the function bundle [%s]", cavemarkMinify))
+	flags.StringVarP(&bundleSourcemap, "sourcemap", "", "", fmt.Sprintf("generate a sourcemap (none, inline, external) [%s]", cavemarkSourcemap))
+	flags.StringVarP(&bundleTarget, "target", "", "", fmt.Sprintf("the ECMAScript version to target, e.g. es2018 [%s]", cavemarkTarget))
+	flags.StringSliceVarP(&bundleDefines, "define", "", nil, fmt.Sprintf("replace a global identifier with a constant, e.g. VERSION='\"1.0.0\"' [%s]", cavemarkDefine))
+	flags.StringSliceVarP(&bundleExternals, "external", "", nil, fmt.Sprintf("a module to exclude from the bundle [%s]", cavemarkExternal))
+	flags.StringSliceVarP(&bundleLoaders, "loader", "", nil, fmt.Sprintf("the loader for a file extension, e.g. .txt=text [%s]", cavemarkLoader))
+
+	bundleAPICheck = resolveStringFlag(bundleAPICheck, cavemarkAPICheck, "warn")
+	bundleMaxSize = resolveStringFlag(bundleMaxSize, cavemarkMaxBundleSize, "")
+	bundleMinify = resolveBoolFlag(bundleMinify, cavemarkMinify)
+	bundleSourcemap = resolveStringFlag(bundleSourcemap, cavemarkSourcemap, "none")
+	bundleTarget = resolveStringFlag(bundleTarget, cavemarkTarget, "esnext")
+	bundleDefines = resolveStringSliceFlag(bundleDefines, cavemarkDefine, nil)
+	bundleExternals = resolveStringSliceFlag(bundleExternals, cavemarkExternal, nil)
+	bundleLoaders = resolveStringSliceFlag(bundleLoaders, cavemarkLoader, nil)
+}
+
+func resolveBoolFlag(value bool, envVar string) bool {
+	b, err := strconv.ParseBool(os.Getenv(envVar))
+	if err != nil {
+		return value
+	}
+	return b
+}
+
+func resolveStringSliceFlag(value []string, envVar string, fallback []string) []string {
+	if len(value) == 0 && os.Getenv(envVar) != "" {
+		value = strings.Split(os.Getenv(envVar), ",")
+	}
+	if len(value) == 0 {
+		return fallback
+	}
+	return value
+}
+
+// resolveStringLinesFlag is resolveStringSliceFlag for values that can contain commas: the
+// environment variable has one value per line and empty lines are skipped.
+func resolveStringLinesFlag(value []string, envVar string, fallback []string) []string {
+	if len(value) == 0 {
+		for _, line := range strings.Split(os.Getenv(envVar), "\n") {
+			if line = strings.TrimSpace(line); line != "" {
+				value = append(value, line)
+			}
+		}
+	}
+	if len(value) == 0 {
+		return fallback
+	}
+	return value
+}
+
+func init() {
+	bundleCmd.Flags().BoolVarP(&bundleAnalyze, "analyze", "a", false, "print the bytes each module contributes to the bundle")
+	addFuncDirFlag(bundleCmd.Flags())
+	addBundleFlags(bundleCmd.Flags())
+	rootCmd.AddCommand(bundleCmd)
+}
@@ -0,0 +1,260 @@
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/pflag"
+)
+
+var (
+	checkSpecs   []string
+	checkTimeout string
+	checkRetries string
+	verify       bool
+)
+
+const (
+	cavemarkCheck        = "CAVEMARK_CHECK"
+	cavemarkCheckTimeout = "CAVEMARK_CHECK_TIMEOUT"
+	cavemarkCheckRetries = "CAVEMARK_CHECK_RETRIES"
+	cavemarkVerify       = "CAVEMARK_VERIFY"
+)
+
+// checkRetryDelay is the time between two attempts of a failing check.
+const checkRetryDelay = 2 * time.Second
+
+// checkBodyLimit is the number of bytes of a response searched for the expected body.
+const checkBodyLimit = 1 << 20
+
+// deployCheck is a smoke test of a deployment: a GET request of a path that must respond with a
+// status and, optionally, a body that contains a substring.
+type deployCheck struct {
+	spec   string
+	path   string
+	status int
+	body   string
+}
+
+// checkTarget is where the checks are sent: the base URL the paths are relative to and the
+// headers of requests to it. Checks of absolute URLs are skipped when relativeOnly is set.
+type checkTarget struct {
+	base         string
+	headers      http.Header
+	relativeOnly bool
+}
+
+// parseCheck parses "path [status] [body substring]", e.g. "/health 200 ok". The status is 200
+// unless given, and the path is either relative to --url or an absolute URL.
+func parseCheck(spec string) (deployCheck, error) {
+	check := deployCheck{spec: spec, status: http.StatusOK}
+	fields := strings.Fields(spec)
+	if len(fields) == 0 {
+		return check, fmt.Errorf("check (%s) has no path", spec)
+	}
+	check.path = fields[0]
+	if !strings.HasPrefix(check.path, "/") && !strings.HasPrefix(check.path, "http://") && !strings.HasPrefix(check.path, "https://") {
+		return check, fmt.Errorf("check (%s) must start with a path or an http(s) URL", spec)
+	}
+	fields = fields[1:]
+	if len(fields) > 0 {
+		status, err := strconv.Atoi(fields[0])
+		if err == nil {
+			if status < 100 || status > 599 {
+				return check, fmt.Errorf("check (%s) has an invalid status %d", spec, status)
+			}
+			check.status = status
+			fields = fields[1:]
+		}
+	}
+	check.body = strings.Join(fields, " ")
+	return check, nil
+}
+
+func parseChecks() ([]deployCheck, error) {
+	checks := make([]deployCheck, 0, len(checkSpecs))
+	for _, spec := range checkSpecs {
+		check, err := parseCheck(spec)
+		if err != nil {
+			return nil, err
+		}
+		checks = append(checks, check)
+	}
+	return checks, nil
+}
+
+func parseCheckTimeout() (time.Duration, error) {
+	timeout, err := time.ParseDuration(checkTimeout)
+	if err != nil || timeout <= 0 {
+		return 0, fmt.Errorf("check timeout (%s) must be a positive duration, e.g. 10s", checkTimeout)
+	}
+	return timeout, nil
+}
+
+func parseCheckRetries() (int, error) {
+	retries, err := strconv.Atoi(checkRetries)
+	if err != nil || retries < 0 {
+		return 0, fmt.Errorf("check retries (%s) must be 0 or more", checkRetries)
+	}
+	return retries, nil
+}
+
+// validateChecks fails before deploying when the checks or their settings are invalid.
+func validateChecks() error {
+	if verify && len(checkSpecs) == 0 {
+		return errors.New("--verify needs at least one --check")
+	}
+	_, err := parseChecks()
+	if err != nil {
+		return err
+	}
+	_, err = parseCheckTimeout()
+	if err != nil {
+		return err
+	}
+	_, err = parseCheckRetries()
+	return err
+}
+
+// runChecks runs every check against target and fails on the first check that still fails after
+// its retries.
+func runChecks(target checkTarget) error {
+	checks, err := parseChecks()
+	if err != nil {
+		return err
+	}
+	timeout, err := parseCheckTimeout()
+	if err != nil {
+		return err
+	}
+	retries, err := parseCheckRetries()
+	if err != nil {
+		return err
+	}
+	client := &http.Client{
+		Timeout: timeout,
+		// a check of a redirect expects the redirect itself
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	for _, check := range checks {
+		p("check", "checking %s", check.spec)
+		if target.relativeOnly && !strings.HasPrefix(check.path, "/") {
+			p("", " [SKIPPED]\n")
+			continue
+		}
+		for attempt := 0; ; attempt++ {
+			err = check.run(client, target)
+			if err == nil {
+				p("", " [OK]\n")
+				break
+			}
+			if attempt == retries {
+				p("", " [FAILED]\n")
+				return fmt.Errorf("check (%s) failed: %w", check.spec, err)
+			}
+			p("", " [retry]")
+			time.Sleep(checkRetryDelay)
+		}
+	}
+	return nil
+}
+
+func (c deployCheck) run(client *http.Client, target checkTarget) error {
+	req, err := http.NewRequest(http.MethodGet, c.path, nil)
+	if strings.HasPrefix(c.path, "/") {
+		req, err = http.NewRequest(http.MethodGet, strings.TrimSuffix(target.base, "/")+c.path, nil)
+		if err == nil {
+			// the headers of the target are only sent to the target
+			for key, values := range target.headers {
+				req.Header[key] = values
+			}
+		}
+	}
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != c.status {
+		return fmt.Errorf("status code = %d, expected %d", resp.StatusCode, c.status)
+	}
+	if c.body == "" {
+		return nil
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, checkBodyLimit))
+	if err != nil {
+		return err
+	}
+	if !bytes.Contains(body, []byte(c.body)) {
+		return fmt.Errorf("body doesn't contain %q", c.body)
+	}
+	return nil
+}
+
+// validateVerify fails before deploying when --verify is used with a server that can't route
+// requests to a deployment before it is activated.
+func validateVerify() error {
+	if verify && !server.supports(featurePreview) {
+		return errors.New("--verify needs a server that supports previews of deployments before activation")
+	}
+	return nil
+}
+
+// verifyDeployment runs the checks against a deployment before it is activated, so no traffic
+// reaches a deployment that fails them. Checks of absolute URLs are skipped.
+func verifyDeployment(deployKey string) error {
+	if !verify {
+		return nil
+	}
+	p("verify", "verifying %s before activation\n", deployKey)
+	err := runChecks(server.previewTarget(deployKey))
+	if err != nil {
+		return fmt.Errorf("verification of %s failed: %w", deployKey, err)
+	}
+	p("verify", "successfully verified %s\n", deployKey)
+	return nil
+}
+
+// checkActivation runs the checks against the activated deployment. When a check fails, the
+// deployment that was active before is activated again.
+func checkActivation(deployKey, previousKey string) error {
+	if len(checkSpecs) == 0 {
+		return nil
+	}
+	err := runChecks(checkTarget{base: url})
+	if err == nil {
+		p("check", "%s serves traffic\n", deployKey)
+		return nil
+	}
+	if previousKey == "" || previousKey == deployKey {
+		return fmt.Errorf("%w, and there is no previous deployment to reactivate", err)
+	}
+	p("rollback", "reactivating %s\n", previousKey)
+	rollbackErr := activateDeployment(previousKey)
+	if rollbackErr != nil {
+		return fmt.Errorf("%v, and reactivating %s failed: %w", err, previousKey, rollbackErr)
+	}
+	return fmt.Errorf("%w, reactivated %s", err, previousKey)
+}
+
+func addCheckFlags(flags *pflag.FlagSet) {
+	flags.StringArrayVarP(&checkSpecs, "check", "", nil, fmt.Sprintf("a check to run after activation as \"path [status] [body substring]\", can be repeated or given one per line in the environment variable [%s]", cavemarkCheck))
+	flags.StringVarP(&checkTimeout, "check-timeout", "", "", fmt.Sprintf("the timeout of a single check request [%s]", cavemarkCheckTimeout))
+	flags.StringVarP(&checkRetries, "check-retries", "", "", fmt.Sprintf("the number of times a failing check is retried [%s]", cavemarkCheckRetries))
+	flags.BoolVarP(&verify, "verify", "", false, fmt.Sprintf("run the checks against the deployment before activating it, on servers that support previews [%s]", cavemarkVerify))
+	checkSpecs = resolveStringLinesFlag(checkSpecs, cavemarkCheck, nil)
+	checkTimeout = resolveStringFlag(checkTimeout, cavemarkCheckTimeout, "10s")
+	checkRetries = resolveStringFlag(checkRetries, cavemarkCheckRetries, "3")
+	verify = resolveBoolFlag(verify, cavemarkVerify)
+}
@@ -0,0 +1,119 @@
+package cmd
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseCheck(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    deployCheck
+		wantErr string
+	}{
+		{
+			spec: "/health",
+			want: deployCheck{path: "/health", status: 200},
+		},
+		{
+			spec: "/old 301",
+			want: deployCheck{path: "/old", status: 301},
+		},
+		{
+			spec: "/ 200 Welcome home",
+			want: deployCheck{path: "/", status: 200, body: "Welcome home"},
+		},
+		{
+			spec: "/ 200 Hello, world",
+			want: deployCheck{path: "/", status: 200, body: "Hello, world"},
+		},
+		{
+			spec: "/health ok",
+			want: deployCheck{path: "/health", status: 200, body: "ok"},
+		},
+		{
+			spec: "  /api/status   503   down  ",
+			want: deployCheck{path: "/api/status", status: 503, body: "down"},
+		},
+		{
+			spec: "https://example.com/health 204",
+			want: deployCheck{path: "https://example.com/health", status: 204},
+		},
+		{
+			spec:    "",
+			wantErr: "check () has no path",
+		},
+		{
+			spec:    "health 200",
+			wantErr: "check (health 200) must start with a path or an http(s) URL",
+		},
+		{
+			spec:    "ftp://example.com/health",
+			wantErr: "check (ftp://example.com/health) must start with a path or an http(s) URL",
+		},
+		{
+			spec:    "/health 99",
+			wantErr: "check (/health 99) has an invalid status 99",
+		},
+		{
+			spec:    "/health 600 ok",
+			wantErr: "check (/health 600 ok) has an invalid status 600",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			got, err := parseCheck(tt.spec)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Errorf("parseCheck() error = %v, want %s", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			tt.want.spec = tt.spec
+			if got != tt.want {
+				t.Errorf("parseCheck() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveCheckSpecs(t *testing.T) {
+	tests := []struct {
+		name  string
+		flags []string
+		env   string
+		want  []string
+	}{
+		{
+			name: "one check with a comma",
+			env:  "/ 200 Hello, world",
+			want: []string{"/ 200 Hello, world"},
+		},
+		{
+			name: "one check per line",
+			env:  "/health 200 ok\n\n/old 301\n",
+			want: []string{"/health 200 ok", "/old 301"},
+		},
+		{
+			name:  "flags win",
+			flags: []string{"/ 200 a, b"},
+			env:   "/health",
+			want:  []string{"/ 200 a, b"},
+		},
+		{
+			name: "unset",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv(cavemarkCheck, tt.env)
+			got := resolveStringLinesFlag(tt.flags, cavemarkCheck, nil)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("resolveStringLinesFlag() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,119 @@
+package cmd
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/spf13/pflag"
+)
+
+var (
+	contentTypeOverrides []string
+	// contentTypes are the parsed --content-type overrides, keyed by extension
+	contentTypes     map[string]string
+	contentTypesErr  error
+	contentTypesOnce sync.Once
+)
+
+const (
+	cavemarkContentType = "CAVEMARK_CONTENT_TYPE"
+)
+
+// extensionContentTypes are the content types of common web files. They take precedence over
+// the system's MIME database so deployments don't depend on the machine they run on.
+var extensionContentTypes = map[string]string{
+	".html":        "text/html; charset=utf-8",
+	".htm":         "text/html; charset=utf-8",
+	".css":         "text/css; charset=utf-8",
+	".js":          "text/javascript; charset=utf-8",
+	".mjs":         "text/javascript; charset=utf-8",
+	".map":         "application/json",
+	".json":        "application/json",
+	".webmanifest": "application/manifest+json",
+	".xml":         "application/xml",
+	".txt":         "text/plain; charset=utf-8",
+	".md":          "text/markdown; charset=utf-8",
+	".csv":         "text/csv; charset=utf-8",
+	".svg":         "image/svg+xml",
+	".png":         "image/png",
+	".jpg":         "image/jpeg",
+	".jpeg":        "image/jpeg",
+	".gif":         "image/gif",
+	".webp":        "image/webp",
+	".avif":        "image/avif",
+	".ico":         "image/x-icon",
+	".wasm":        "application/wasm",
+	".woff":        "font/woff",
+	".woff2":       "font/woff2",
+	".ttf":         "font/ttf",
+	".otf":         "font/otf",
+	".eot":         "application/vnd.ms-fontobject",
+	".pdf":         "application/pdf",
+	".zip":         "application/zip",
+	".mp3":         "audio/mpeg",
+	".mp4":         "video/mp4",
+	".webm":        "video/webm",
+}
+
+// contentTypeOf returns the content type of a resource or static file. The --content-type
+// overrides are checked first, then the file extension, and the contents are sniffed last.
+func contentTypeOf(f string, contents []byte) (string, error) {
+	ext := strings.ToLower(filepath.Ext(f))
+	overrides, err := contentTypeOverridesByExt()
+	if err != nil {
+		return "", err
+	}
+	if contentType, ok := overrides[ext]; ok {
+		return contentType, nil
+	}
+	if contentType, ok := extensionContentTypes[ext]; ok {
+		return contentType, nil
+	}
+	if contentType := mime.TypeByExtension(ext); ext != "" && contentType != "" {
+		return contentType, nil
+	}
+	return http.DetectContentType(contents), nil
+}
+
+// contentTypeOverridesByExt parses the --content-type overrides the first time they are needed.
+func contentTypeOverridesByExt() (map[string]string, error) {
+	contentTypesOnce.Do(func() {
+		contentTypes, contentTypesErr = parseContentTypes(contentTypeOverrides)
+	})
+	return contentTypes, contentTypesErr
+}
+
+// validateContentTypes fails before deploying when a --content-type override is invalid, instead
+// of when the first file is uploaded.
+func validateContentTypes() error {
+	_, err := contentTypeOverridesByExt()
+	return err
+}
+
+func parseContentTypes(values []string) (map[string]string, error) {
+	pairs, err := parsePairs(values, "content type")
+	if err != nil {
+		return nil, err
+	}
+	contentTypes := make(map[string]string)
+	for ext, contentType := range pairs {
+		if !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
+		}
+		_, _, err := mime.ParseMediaType(contentType)
+		if err != nil {
+			return nil, fmt.Errorf("content type (%s) for %s is invalid: %w", contentType, ext, err)
+		}
+		contentTypes[strings.ToLower(ext)] = contentType
+	}
+	return contentTypes, nil
+}
+
+func addContentTypeFlags(flags *pflag.FlagSet) {
+	flags.StringSliceVarP(&contentTypeOverrides, "content-type", "", nil, fmt.Sprintf("the content type for a file extension, e.g. .webmanifest=application/manifest+json [%s]", cavemarkContentType))
+	contentTypeOverrides = resolveStringSliceFlag(contentTypeOverrides, cavemarkContentType, nil)
+}
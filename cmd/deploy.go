@@ -16,8 +16,7 @@ import (
 	"github.com/fsnotify/fsnotify"
 
 	"github.com/spf13/cobra"
-
-	"github.com/evanw/esbuild/pkg/api"
+	"github.com/spf13/pflag"
 )
 
 var (
@@ -29,6 +28,7 @@ var (
 	strategy           string
 	manualDeployKey    string
 	watch              bool
+	dryRun             bool
 )
 
 const (
@@ -36,6 +36,7 @@ const (
 	cavemarkStaticDir   = "CAVEMARK_STATIC_DIR"
 	cavemarkResourceDir = "CAVEMARK_RESOURCE_DIR"
 	cavemarkStrategy    = "CAVEMARK_STRATEGY"
+	cavemarkDryRun      = "CAVEMARK_DRY_RUN"
 )
 
 var deployCmd = &cobra.Command{
@@ -53,15 +54,148 @@ Any environment variable that starts with CAVEMARK_ will be deployed to Cavemark
 Secrets will be available to Cavemark functions without the CAVEMARK_SECRET. For example,
 CAVEMARK_SECRET_PG_CONNECTION will be available as PG_CONNECTION.
 
+Bundling:
+The function entry point is the first of index.ts, index.tsx, index.mjs or index.js found in
+the function directory. An index.mjs entry point is an ES module that exports its main function.
+Functions are bundled and minified with esbuild. Use --minify=false to keep the bundle readable
+when debugging stack traces, and --sourcemap to either inline a sourcemap or upload it as a
+separate artifact (external). Imports of .json, .txt and .html files are supported by default.
+Use --typecheck to run tsc before bundling TypeScript. Uses of namespace.v1 members that aren't
+part of the runtime API, and imports of Node built-ins, are reported as warnings; use
+--api-check=error to fail the deployment instead.
+
+Content types:
+Resource and static files get their content type from the file extension, e.g. text/css for
+.css files. Files with an unknown extension are sniffed. Use --content-type to override the
+content type of an extension. Use --dry-run to list the files and their content types without
+deploying.
+
+Templates:
+Resource files with the .mustache extension are parsed before deploying, so a syntax error fails
+the deployment instead of the request that renders it. Use --validate-template to validate other
+templates as well, e.g. --validate-template "emails/*.html".
+
+Precompression:
+Use --precompress to upload a brotli (.br) and a gzip (.gz) variant next to every compressible
+static file of at least --precompress-min-size. Variants are uploaded with a Content-Encoding
+and are skipped when they aren't smaller than the file. Images, fonts like WOFF2, archives and
+media are already compressed and are never precompressed.
+
+Uploads:
+Files are streamed from disk instead of being read into memory, and their content type is
+sniffed from the first 512 bytes when the extension is unknown. Files larger than
+--upload-chunk-size are uploaded in chunks with a Content-Range header and their progress is
+printed, when the server supports chunked uploads. A chunk that fails is sent again, and running
+an interrupted deployment again resumes its uploads where they stopped.
+
+Batch uploads:
+When the server supports it, the function bundle, resource files and static files are uploaded
+as one zip archive in the format of "cavemark build" instead of one request per file. Secrets
+are never part of the archive. The whole site is written to a zip file in the temporary
+directory before it is uploaded, which needs as much free disk space as the site, and the file
+is removed afterwards. Deployments with fewer resource and static files than --batch-min-files
+(10 unless set, 0 always batches), servers without batch uploads and --batch=false get one
+request per file.
+
+Server capabilities:
+Before deploying, the cli asks the server for its version, the features it supports and its
+limits with GET /cvmrk/cli/info, and fails right away when the server and the cli don't speak a
+common protocol version or the server requires a newer cli. Batch and chunked uploads are only
+used when the server supports them, chunks never exceed the largest request the server accepts,
+and a file larger than the server accepts fails the deployment before it begins. When the server
+supports deletes, a deployment that fails halfway is deleted again. Servers without the info
+endpoint are assumed to support none of these features.
+
+Fingerprinting:
+Use --fingerprint to add a hash of the contents to the names of CSS, JavaScript, image, font and
+WebAssembly static files, e.g. css/app.css is deployed as css/app.3f9a1c2b.css, so they can be
+cached forever. References in HTML pages, CSS files, JavaScript files and resource templates are
+rewritten to the new names. In JavaScript, only strings that are absolute paths or start with ./
+or ../ are rewritten, and relative ones are resolved from the JavaScript file, the way imports
+are. The mapping is deployed with the resource files as asset-manifest.json.
+
+Headers:
+Static files get the headers of the rules in the _headers file of the working directory, or the
+file set with --headers-file. A rule is a path pattern, in which * matches any characters, followed
+by indented headers. The headers of every matching rule are combined and a later rule overrides
+a header of an earlier one. Patterns match the path of a file in the static directory, before
+fingerprinting. For example:
+
+  /*
+    X-Content-Type-Options: nosniff
+  /css/*
+    Cache-Control: public, max-age=31536000, immutable
+
+Use --dry-run to list the headers of every file. Headers are only deployed to servers that
+support them, with any other server the deployment fails before it begins.
+
+Redirects:
+Redirect and rewrite rules for static files are read from the _redirects file of the working
+directory, or the file set with --redirects-file, and deployed as the static file
+_redirects.json. A rule is a path, a target and an optional status (301 by default). Paths can
+contain :name placeholders and end in *, which the target can use as :name and :splat. A 200 rule
+serves the target file instead, a 404 rule serves it with status 404. Rules are skipped for paths
+of existing static files unless the status ends in !. "trailing-slash add" or "trailing-slash
+remove" redirects paths to the same path with or without a trailing slash. Rules that can never
+match and redirects that loop fail the deployment. For example:
+
+  trailing-slash remove
+  /blog/:year/:slug  /posts/:slug  301
+  /docs/*            https://docs.example.com/:splat  302
+  /*                 /index.html  200
+
+Ignoring files:
+Hidden files are never deployed. Files and directories matched by a .cavemarkignore file are
+left out as well, e.g. editor swap files, READMEs or node_modules. The patterns have the same
+syntax as a .gitignore file. A .cavemarkignore file in the working directory applies to the
+function, resource and static directories, one in a subdirectory applies to that subdirectory.
+Ignored files aren't watched either. Use --show-ignored to print the ignored files and the
+pattern that matched them.
+
+Checks:
+Use --check to make sure the app serves traffic after activation. A check is a path, an optional
+status (200 by default) and an optional text the body must contain, e.g. "/health 200 ok". Paths
+are relative to --url, or absolute URLs. Redirects aren't followed, so a check can expect a 301.
+CAVEMARK_CHECK has one check per line, since the text of a check can contain commas.
+A failing check is retried --check-retries times, and each request times out after
+--check-timeout. When a check still fails, the deployment that was active before is activated
+again and the deployment fails.
+
+Use --verify to run the checks against the deployment after uploading and before activating it,
+so no traffic reaches a deployment that fails them. This needs a server that supports previews:
+checks are sent to the preview URL of the server, or to --url, with an X-Cavemark-Deploy-Key
+header that routes them to the deployment. The api keys are never sent with checks, so the
+server decides whether to serve a deployment that isn't active. Checks of absolute URLs are
+skipped. A deployment that fails verification isn't activated.
+
+Artifacts:
+Use --artifact to deploy an artifact created by "cavemark build" instead of bundling and
+globbing the project directories. Secrets are still read from the environment.
+
 Examples:
   # deploys all *.js files recursively in the "src" directory to http://localhost:9090 using the bluegreen strategy
   cavemark deploy
 
   # deploys all *.js files recursively in ~/dev/project/server to https://example.com using the word 'example'' as the deployment key
   # also, deploys all files (except hidden) in ~/dev/project/assets to https://example.com as static assets using the same strategy
-  cavemark deploy -f ~/dev/project/server -s ~/dev/project/assets -u https://example.com -g manual -k example`,
+  cavemark deploy -f ~/dev/project/server -s ~/dev/project/assets -u https://example.com -g manual -k example
+
+  # deploys and reactivates the previous deployment unless /health responds with 200 and "ok"
+  cavemark deploy -u https://example.com --check "/health 200 ok" --check /
+
+  # checks the deployment before activating it, and again after activating it
+  cavemark deploy -u https://example.com --check "/health 200 ok" --verify`,
 	Args: cobra.MaximumNArgs(0),
 	RunE: func(cmd *cobra.Command, args []string) error {
+		if showIgnored {
+			err := printIgnoredFiles()
+			if err != nil {
+				return err
+			}
+		}
+		if dryRun {
+			return deployDryRun(cmd.Parent().Version)
+		}
 		printDeployHeader(cmd.Parent().Version)
 
 		err := validate()
@@ -69,6 +203,15 @@ Examples:
 			return err
 		}
 
+		err = negotiate(cmd.Parent().Version)
+		if err != nil {
+			return err
+		}
+		err = validateVerify()
+		if err != nil {
+			return err
+		}
+
 		strategyFunc, err := resolveStrategy()
 		if err != nil {
 			return err
@@ -101,6 +244,24 @@ func validate() error {
 	if apiSecretKey == "" {
 		return errors.New("api secret key is required")
 	}
+	err := validateChecks()
+	if err != nil {
+		return err
+	}
+	_, err = parseBatchMinFiles()
+	if err != nil {
+		return err
+	}
+	if deployArtifact != "" {
+		if watch {
+			return errors.New("an artifact can't be watched for changes")
+		}
+		return nil
+	}
+	return validateProject()
+}
+
+func validateProject() error {
 	indexExists, err := indexFunctionExists()
 	if err != nil {
 		return err
@@ -110,20 +271,42 @@ func validate() error {
 		return err
 	}
 	if !indexExists && !staticsExist {
-		return errors.New("no index.js or static files to deploy")
+		return errors.New("no index.js, index.mjs, index.ts, index.tsx or static files to deploy")
 	}
-	return nil
+	err = validateContentTypes()
+	if err != nil {
+		return err
+	}
+	_, err = loadHeaderRules()
+	if err != nil {
+		return err
+	}
+	_, err = loadRedirectRules()
+	return err
+}
+
+var entryPointNames = []string{"index.ts", "index.tsx", "index.mjs", "index.js"}
+
+func entryPoint() (string, error) {
+	for _, name := range entryPointNames {
+		entry := path.Join(funcDir, name)
+		_, err := os.Lstat(entry)
+		if err == nil {
+			return entry, nil
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return "", err
+		}
+	}
+	return "", nil
 }
 
 func indexFunctionExists() (bool, error) {
-	_, err := os.Lstat(path.Join(funcDir, "index.js"))
+	entry, err := entryPoint()
 	if err != nil {
-		if errors.Is(err, os.ErrNotExist) {
-			return false, nil
-		}
 		return false, err
 	}
-	return true, nil
+	return entry != "", nil
 }
 
 func staticFilesExist() (bool, error) {
@@ -163,6 +346,52 @@ func resolveStrategy() (strategyFunction, error) {
 	}
 }
 
+// deployDryRun prints the files a deployment would upload, with their content types, without
+// contacting Cavemark.
+func deployDryRun(version string) error {
+	p("cavemark", "version %s\n", version)
+	p("cavemark", "dry run, nothing will be deployed\n")
+	err := validateContentTypes()
+	if err != nil {
+		return err
+	}
+	var files []artifactFile
+	if deployArtifact != "" {
+		r, err := openArtifact(deployArtifact)
+		if err != nil {
+			return err
+		}
+		defer func() { _ = r.Close() }()
+		files = r.manifest.Files
+	} else {
+		err := validateProject()
+		if err != nil {
+			return err
+		}
+		w := newArtifactWriter(ioutil.Discard, version)
+		err = addArtifactFiles(w)
+		if err != nil {
+			p("error", "%s\n", err)
+			return err
+		}
+		files = w.manifest.Files
+	}
+
+	fmt.Println()
+	fmt.Println("Kind     \tSize      \tContent Type                   \tPath")
+	fmt.Println("---------\t----------\t------------------------------\t----")
+	for _, f := range files {
+		fmt.Printf("%-9s\t%10s\t%-30s\t%s\n", f.Kind, formatByteSize(f.Size), f.ContentType, f.Path)
+		for _, key := range sortedHeaderNames(f.Headers) {
+			fmt.Printf("%-9s\t%10s\t%-30s\t  %s: %s\n", "", "", "", key, f.Headers[key])
+		}
+	}
+	for _, v := range envSecrets() {
+		fmt.Printf("%-9s\t%10s\t%-30s\t%s\n", "secret", "", "", v.key)
+	}
+	return nil
+}
+
 func printDeployHeader(version string) {
 	p("cavemark", "version %s\n", version)
 	p("cavemark", "starting deployment to %s\n", url)
@@ -185,6 +414,66 @@ func startWatching(fn strategyFunction) error {
 	}
 	defer func() { _ = watcher.Close() }()
 
+	dirs := make([]string, 0, 3)
+	matchers := make(map[string]*ignoreMatcher)
+	for _, dir := range []string{funcDir, resourceDir, staticDir} {
+		if dir == "" {
+			continue
+		}
+		matchers[dir], err = newIgnoreMatcher(dir)
+		if err != nil {
+			return err
+		}
+		dirs = append(dirs, dir)
+	}
+	// the rules files and the ignore file of the working directory are usually outside of the
+	// watched directories, their directories are watched as well, but only changes to these
+	// files count
+	ruleFiles := make(map[string]bool)
+	for _, f := range []string{headersFile, redirectsFile, ignoreFileName} {
+		if f != "" {
+			ruleFiles[filepath.Clean(f)] = true
+		}
+	}
+	ignoredChange := func(name string) bool {
+		if filepath.Base(name) == ignoreFileName {
+			// patterns may have changed, ignore files are read again
+			for _, matcher := range matchers {
+				matcher.reset()
+			}
+			return false
+		}
+		if ruleFiles[filepath.Clean(name)] {
+			return false
+		}
+		// the deepest directory that contains the file decides, e.g. for a static directory inside
+		// the function directory
+		match, depth := "", -1
+		for _, dir := range dirs {
+			rel, err := filepath.Rel(dir, name)
+			if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+				continue
+			}
+			abs, err := filepath.Abs(dir)
+			if err != nil {
+				continue
+			}
+			if d := strings.Count(abs, string(filepath.Separator)); d > depth {
+				match, depth = dir, d
+			}
+		}
+		if match == "" {
+			// another file in the directory of a rules file
+			return true
+		}
+		isDir := false
+		if info, err := os.Stat(name); err == nil {
+			isDir = info.IsDir()
+		}
+		ignored, _, _ := matchers[match].ignored(name, isDir)
+		return ignored
+	}
+
 	done := make(chan bool)
 	go func() {
 		for {
@@ -193,7 +482,7 @@ func startWatching(fn strategyFunction) error {
 				if !ok {
 					return
 				}
-				if event.Op&fsnotify.Write == fsnotify.Write {
+				if event.Op&fsnotify.Write == fsnotify.Write && !ignoredChange(event.Name) {
 					fmt.Printf("\n\n")
 					p("watch", "detected file system change\n")
 					err = fn()
@@ -211,33 +500,38 @@ func startWatching(fn strategyFunction) error {
 		}
 	}()
 
-	err = filepath.WalkDir(funcDir, func(path string, d fs.DirEntry, err error) error {
-		if d != nil && d.IsDir() {
-			p("watch", path+"\n")
-			return watcher.Add(path)
-		}
-		return nil
-	})
-	if resourceDir != "" {
-		err = filepath.WalkDir(resourceDir, func(path string, d fs.DirEntry, err error) error {
-			if d != nil && d.IsDir() {
-				p("watch", path+"\n")
-				return watcher.Add(path)
-			}
-			return nil
-		})
-	}
-	if staticDir != "" {
-		err = filepath.WalkDir(staticDir, func(path string, d fs.DirEntry, err error) error {
+	for _, dir := range dirs {
+		err = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
 			if d != nil && d.IsDir() {
+				if path != dir {
+					ignored, _, err := matchers[dir].ignored(path, true)
+					if err != nil {
+						return err
+					}
+					if ignored {
+						return filepath.SkipDir
+					}
+				}
 				p("watch", path+"\n")
 				return watcher.Add(path)
 			}
 			return nil
 		})
+		if err != nil {
+			return err
+		}
 	}
-	if err != nil {
-		return err
+	for f := range ruleFiles {
+		_, err = os.Stat(f)
+		if err != nil && f != ignoreFileName {
+			// an ignore file can be added while watching
+			continue
+		}
+		p("watch", f+"\n")
+		err = watcher.Add(filepath.Dir(f))
+		if err != nil {
+			return err
+		}
 	}
 	<-done
 
@@ -245,10 +539,29 @@ func startWatching(fn strategyFunction) error {
 }
 
 func httpCall(method, url, contentType string, body io.Reader) (*http.Response, error) {
+	return httpCallWithHeaders(method, url, contentType, nil, body)
+}
+
+func httpCallWithHeaders(method, url, contentType string, headers http.Header, body io.Reader) (*http.Response, error) {
+	return httpCallWithLength(method, url, contentType, headers, body, -1)
+}
+
+// httpCallWithLength sends a body that is streamed with a Content-Length of size. A size of -1
+// leaves the Content-Length to net/http, which knows it for in-memory bodies only.
+func httpCallWithLength(method, url, contentType string, headers http.Header, body io.Reader, size int64) (*http.Response, error) {
 	req, err := http.NewRequest(method, url, body)
 	if err != nil {
 		return nil, err
 	}
+	if size >= 0 {
+		req.ContentLength = size
+		if size == 0 {
+			req.Body = http.NoBody
+		}
+	}
+	for key, values := range headers {
+		req.Header[key] = values
+	}
 	req.Header.Set("Content-Type", contentType)
 	if apiKey != "" {
 		req.Header.Set("API_KEY", apiKey)
@@ -291,51 +604,94 @@ func manual() error {
 
 func deploy(deployKey string) error {
 	p(strategy, "deploying to %s\n", deployKey)
-	err := beginDeployment(deployKey)
+	err := checkFileSizes()
 	if err != nil {
 		return err
 	}
-	err = deploySecrets(deployKey)
+	err = checkHeaderSupport()
 	if err != nil {
 		return err
 	}
-	err = deployFunction(deployKey)
+	previousKey, err := getDeployKey()
 	if err != nil {
-		return err
+		return fmt.Errorf("error getting deploy key: %w", err)
 	}
-	err = deployResources(deployKey)
+	err = beginDeployment(deployKey)
 	if err != nil {
 		return err
 	}
-	err = deployStatics(deployKey)
+	err = deployContents(deployKey)
+	if err == nil {
+		err = verifyDeployment(deployKey)
+	}
 	if err != nil {
+		discardDeployment(deployKey)
 		return err
 	}
 	err = activateDeployment(deployKey)
 	if err != nil {
 		return err
 	}
-	return nil
+	return checkActivation(deployKey, previousKey)
 }
 
+// deployContents uploads the secrets, function, resources and statics of a begun deployment.
+func deployContents(deployKey string) error {
+	err := deploySecrets(deployKey)
+	if err != nil {
+		return err
+	}
+	batch, err := batchSupported()
+	if err != nil {
+		return err
+	}
+	if batch {
+		return deployBatch(deployKey)
+	}
+	if deployArtifact != "" {
+		return deployArtifactFiles(deployKey)
+	}
+	err = deployFunction(deployKey)
+	if err != nil {
+		return err
+	}
+	fp, err := fingerprintAssets()
+	if err != nil {
+		return err
+	}
+	err = deployResources(deployKey, fp)
+	if err != nil {
+		return err
+	}
+	return deployStatics(deployKey, fp)
+}
+
+// getDeployKey returns the key of the active deployment, or an empty key when the server has no
+// active deployment yet.
 func getDeployKey() (string, error) {
-	resp, err := httpGet(fmt.Sprintf("%s/cvmrk/cli/deploy", url))
+	resp, err := httpGet(apiURL("/deploy"))
 	if err != nil {
 		return "", err
 	}
 	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode == http.StatusNotFound {
+		return "", nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to get deploy key: status code = %d", resp.StatusCode)
+	}
 	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
 		return "", err
 	}
-	return string(body), nil
+	return strings.TrimSpace(string(body)), nil
 }
 
 func deploySecrets(deployKey string) error {
 	p("secrets", "starting to deploy secrets\n")
 	for _, v := range envSecrets() {
 		p("secrets", "deploying %s", v.key)
-		resp, err := httpPut(fmt.Sprintf("%s/cvmrk/cli/deploy/%s/secret/%s", url, deployKey, v.key), "text/plain", strings.NewReader(v.value))
+		resp, err := httpPut(apiURL("/deploy/%s/secret/%s", deployKey, v.key), "text/plain", strings.NewReader(v.value))
 		if err != nil {
 			return fmt.Errorf("error deploying secret (%s): %w", v.key, err)
 		}
@@ -350,27 +706,6 @@ func deploySecrets(deployKey string) error {
 	return nil
 }
 
-func bundle() ([]byte, error) {
-	entryFile := path.Join(funcDir, "index.js")
-	result := api.Build(api.BuildOptions{
-		Bundle:           true,
-		MinifySyntax:     true,
-		MinifyWhitespace: true,
-		Color:            api.ColorNever,
-		TreeShaking:      api.TreeShakingFalse,
-		EntryPoints:      []string{entryFile},
-		Platform:         api.PlatformNode,
-		LogLevel:         api.LogLevelInfo,
-	})
-	if len(result.Errors) > 0 {
-		for _, err := range result.Errors {
-			fmt.Println(err.Text)
-		}
-		return nil, errors.New("error while bundling")
-	}
-	return result.OutputFiles[0].Contents, nil
-}
-
 func deployFunction(deployKey string) error {
 	indexExists, err := indexFunctionExists()
 	if err != nil {
@@ -380,15 +715,33 @@ func deployFunction(deployKey string) error {
 		return nil
 	}
 	p("functions", "starting to deploy functions in '%s'\n", funcDir)
+	if typecheck {
+		err = runTypecheck()
+		if err != nil {
+			return err
+		}
+	}
 	p("functions", "creating bundle")
-	content, err := bundle()
+	result, err := bundle()
 	if err != nil {
 		return err
 	}
 	p("", " [OK]\n")
+	err = checkBundleSize(result)
+	if err != nil {
+		return err
+	}
+	err = deployBundle(deployKey, result)
+	if err != nil {
+		return err
+	}
+	p("functions", "successfully deployed\n")
+	return nil
+}
 
+func deployBundle(deployKey string, result *bundleResult) error {
 	p("functions", "deploying bundle")
-	resp, err := httpPut(fmt.Sprintf("%s/cvmrk/cli/deploy/%s/function", url, deployKey), "text/plain", bytes.NewReader(content))
+	resp, err := httpPut(apiURL("/deploy/%s/function", deployKey), "text/plain", bytes.NewReader(result.code))
 	if err != nil {
 		return fmt.Errorf("error deploying bundle: %w", err)
 	}
@@ -398,50 +751,85 @@ func deployFunction(deployKey string) error {
 		p("", " [%d]\n", resp.StatusCode)
 		return fmt.Errorf("failed to deploy bundle")
 	}
-	p("functions", "successfully deployed\n")
+
+	if result.sourceMap != nil {
+		p("functions", "deploying sourcemap")
+		resp, err = httpPut(apiURL("/deploy/%s/function/sourcemap", deployKey), "application/json", bytes.NewReader(result.sourceMap))
+		if err != nil {
+			return fmt.Errorf("error deploying sourcemap: %w", err)
+		}
+		if resp.StatusCode == http.StatusNoContent {
+			p("", " [OK]\n")
+		} else {
+			p("", " [%d]\n", resp.StatusCode)
+			return fmt.Errorf("failed to deploy sourcemap")
+		}
+	}
 	return nil
 }
 
-func deployResources(deployKey string) error {
+// deployResources uploads the resource files and, when fingerprinting, the asset manifest.
+func deployResources(deployKey string, fp *assetFingerprints) error {
 	if resourceDir == "" {
-		return nil
+		return deployAssetManifest(deployKey, fp)
 	}
 	_, err := os.Lstat(resourceDir)
 	if err != nil {
 		if strings.HasSuffix(err.Error(), "no such file or directory") && resourceDir == defaultResourceDir {
-			return nil
+			return deployAssetManifest(deployKey, fp)
 		}
 		return err
 	}
 	p("resources", "starting to deploy resource files in '%s'\n", resourceDir)
+	err = validateTemplates(resourceDir, defaultResourceDir)
+	if err != nil {
+		return err
+	}
 	files, err := globAll(resourceDir)
 	if err != nil {
 		return fmt.Errorf("error globbing files: %w", err)
 	}
 	for _, f := range files {
-		p("resources", "deploying file %s", f)
-		contents, err := ioutil.ReadFile(f)
+		body, err := fileBody(f)
 		if err != nil {
-			return fmt.Errorf("error reading file (%s): %w", f, err)
+			return err
 		}
 		filePath := filepath.ToSlash(removeDir(f, resourceDir))
-		contentType := http.DetectContentType(contents)
-		resp, err := httpPut(fmt.Sprintf("%s/cvmrk/cli/deploy/%s/resource/%s", url, deployKey, filePath), contentType, bytes.NewReader(contents))
+		contentType, err := sniffContentType(f)
 		if err != nil {
-			return fmt.Errorf("error deploying resource file (%s): %w", f, err)
+			return err
 		}
-		if resp.StatusCode == http.StatusNoContent {
-			p("", " [OK]\n")
-		} else {
-			p("", " [%d]\n", resp.StatusCode)
-			return fmt.Errorf("failed to deploy resource file (%s)", f)
+		filePath, body, err = fp.apply("resource", filePath, body)
+		if err != nil {
+			return err
+		}
+		p("resources", "deploying file %s (%s)", f, contentType)
+		err = deployFile(deployKey, "resource", filePath, contentType, body)
+		if err != nil {
+			return err
 		}
 	}
+	err = deployAssetManifest(deployKey, fp)
+	if err != nil {
+		return err
+	}
 	p("resources", "successfully deployed\n")
 	return nil
 }
 
-func deployStatics(deployKey string) error {
+func deployStatics(deployKey string, fp *assetFingerprints) error {
+	err := deployStaticDir(deployKey, fp)
+	if err != nil {
+		return err
+	}
+	err = deployRedirects(deployKey)
+	if err != nil {
+		return err
+	}
+	return deployOpenAPI(deployKey)
+}
+
+func deployStaticDir(deployKey string, fp *assetFingerprints) error {
 	if staticDir == "" {
 		return nil
 	}
@@ -457,29 +845,69 @@ func deployStatics(deployKey string) error {
 	if err != nil {
 		return fmt.Errorf("error globbing files: %w", err)
 	}
+	rules, err := loadHeaderRules()
+	if err != nil {
+		return err
+	}
+	stats := newPrecompressStats()
 	for _, f := range files {
-		p("statics", "deploying file %s", f)
-		contents, err := ioutil.ReadFile(f)
+		body, err := fileBody(f)
 		if err != nil {
-			return fmt.Errorf("error reading file (%s): %w", f, err)
+			return err
 		}
 		filePath := filepath.ToSlash(removeDir(f, staticDir))
-		contentType := http.DetectContentType(contents)
-		resp, err := httpPut(fmt.Sprintf("%s/cvmrk/cli/deploy/%s/static/%s", url, deployKey, filePath), contentType, bytes.NewReader(contents))
+		contentType, err := sniffContentType(f)
 		if err != nil {
-			return fmt.Errorf("error deploying static file (%s): %w", f, err)
+			return err
 		}
-		if resp.StatusCode == http.StatusNoContent {
-			p("", " [OK]\n")
+		headers := rules.match(filePath)
+		filePath, body, err = fp.apply("static", filePath, body)
+		if err != nil {
+			return err
+		}
+		if fingerprint {
+			p("statics", "deploying file %s as %s (%s)", f, filePath, contentType)
 		} else {
-			p("", " [%d]\n", resp.StatusCode)
-			return fmt.Errorf("failed to deploy static file (%s)", f)
+			p("statics", "deploying file %s (%s)", f, contentType)
+		}
+		err = deployStaticFile(deployKey, filePath, contentType, headers, body, stats)
+		if err != nil {
+			return err
 		}
 	}
+	stats.print()
 	p("statics", "successfully deployed\n")
 	return nil
 }
 
+// deployFile uploads a single resource or static file to the deployment.
+func deployFile(deployKey, kind, filePath, contentType string, body uploadBody) error {
+	return deployFileWithHeaders(deployKey, kind, filePath, contentType, nil, body)
+}
+
+// deployFileWithHeaders uploads a file with additional request headers, such as the
+// Content-Encoding of a precompressed static file or the headers from the header rules. Files
+// larger than --upload-chunk-size are uploaded in chunks.
+func deployFileWithHeaders(deployKey, kind, filePath, contentType string, headers http.Header, body uploadBody) error {
+	err := server.checkFileSize(kind+"/"+filePath, body.size)
+	if err != nil {
+		p("", " [ERROR]\n")
+		return err
+	}
+	status, err := upload(apiURL("/deploy/%s/%s/%s", deployKey, kind, filePath), contentType, headers, body)
+	if err != nil {
+		p("", " [ERROR]\n")
+		return fmt.Errorf("error deploying %s file (%s): %w", kind, filePath, err)
+	}
+	if status == http.StatusNoContent {
+		p("", " [OK]\n")
+	} else {
+		p("", " [%d]\n", status)
+		return fmt.Errorf("failed to deploy %s file (%s)", kind, filePath)
+	}
+	return nil
+}
+
 func removeDir(f, dir string) string {
 	s := strings.Replace(f, dir, "", 1)
 	if strings.HasPrefix(s, "/") {
@@ -490,7 +918,7 @@ func removeDir(f, dir string) string {
 
 func beginDeployment(deployKey string) error {
 	p("begin", "starting deployment %s\n", deployKey)
-	resp, err := httpPost(fmt.Sprintf("%s/cvmrk/cli/deploy/%s/begin", url, deployKey), "text/plain", nil)
+	resp, err := httpPost(apiURL("/deploy/%s/begin", deployKey), "text/plain", nil)
 	if err != nil {
 		return fmt.Errorf("error starting deployment: %w", err)
 	}
@@ -502,9 +930,34 @@ func beginDeployment(deployKey string) error {
 	return nil
 }
 
+// discardDeployment deletes a deployment that failed halfway, when the server supports deletes.
+// The active deployment is never deleted, and failing to delete only prints an error, since the
+// deployment has failed already.
+func discardDeployment(deployKey string) {
+	if !server.supports(featureDelete) {
+		return
+	}
+	activeKey, err := getDeployKey()
+	if err != nil || activeKey == deployKey {
+		return
+	}
+	p("discard", "discarding deployment %s", deployKey)
+	resp, err := httpCall(http.MethodDelete, apiURL("/deploy/%s", deployKey), "text/plain", nil)
+	if err != nil {
+		p("", " [ERROR]\n")
+		return
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode == http.StatusNoContent {
+		p("", " [OK]\n")
+	} else {
+		p("", " [%d]\n", resp.StatusCode)
+	}
+}
+
 func activateDeployment(deployKey string) error {
 	p("activate", "starting to activate %s\n", deployKey)
-	resp, err := httpPost(fmt.Sprintf("%s/cvmrk/cli/deploy/%s/activate", url, deployKey), "text/plain", nil)
+	resp, err := httpPost(apiURL("/deploy/%s/activate", deployKey), "text/plain", nil)
 	if err != nil {
 		return fmt.Errorf("error activating deployment: %w", err)
 	}
@@ -516,18 +969,35 @@ func activateDeployment(deployKey string) error {
 	return nil
 }
 
+// globAll returns the files in dir and its subdirectories, except hidden files and the files
+// ignored by .cavemarkignore files.
 func globAll(dir string) ([]string, error) {
+	matcher, err := newIgnoreMatcher(dir)
+	if err != nil {
+		return nil, err
+	}
 	files := make([]string, 0)
-	err := filepath.Walk(dir, func(path string, f os.FileInfo, err error) error {
+	err = filepath.Walk(dir, func(path string, f os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
 		if f.IsDir() {
-			return nil
+			if path == dir {
+				return nil
+			}
+			ignored, _, err := matcher.ignored(path, true)
+			if err != nil || !ignored {
+				return err
+			}
+			return filepath.SkipDir
 		}
 		if strings.HasPrefix(filepath.Base(path), ".") {
 			return nil
 		}
+		ignored, _, err := matcher.ignored(path, false)
+		if err != nil || ignored {
+			return err
+		}
 		files = append(files, path)
 		return nil
 	})
@@ -561,17 +1031,47 @@ func resolveStringFlag(value, envVar, fallback string) string {
 	return value
 }
 
+func addFuncDirFlag(flags *pflag.FlagSet) {
+	flags.StringVarP(&funcDir, "func-dir", "f", "", fmt.Sprintf("the directory that contains functions to deploy [%s]", cavemarkFuncDir))
+	funcDir = resolveStringFlag(funcDir, cavemarkFuncDir, "src")
+}
+
+func addResourceDirFlag(flags *pflag.FlagSet) {
+	flags.StringVarP(&resourceDir, "resource-dir", "r", "", fmt.Sprintf("the directory that contains resource files to deploy [%s]", cavemarkResourceDir))
+	resourceDir = resolveStringFlag(resourceDir, cavemarkResourceDir, defaultResourceDir)
+}
+
+func addProjectDirFlags(flags *pflag.FlagSet) {
+	addFuncDirFlag(flags)
+	addResourceDirFlag(flags)
+	flags.StringVarP(&staticDir, "static-dir", "s", "", fmt.Sprintf("the directory that contains static assets to deploy [%s]", cavemarkStaticDir))
+	staticDir = resolveStringFlag(staticDir, cavemarkStaticDir, defaultStaticDir)
+}
+
 func init() {
-	deployCmd.Flags().StringVarP(&funcDir, "func-dir", "f", "", fmt.Sprintf("the directory that contains functions to deploy [%s]", cavemarkFuncDir))
-	deployCmd.Flags().StringVarP(&resourceDir, "resource-dir", "r", "", fmt.Sprintf("the directory that contains resource files to deploy [%s]", cavemarkResourceDir))
-	deployCmd.Flags().StringVarP(&staticDir, "static-dir", "s", "", fmt.Sprintf("the directory that contains static assets to deploy [%s]", cavemarkStaticDir))
+	addProjectDirFlags(deployCmd.Flags())
 	deployCmd.Flags().StringVarP(&strategy, "strategy", "g", "", fmt.Sprintf("the deployment strategy (bluegreen, manual) [%s]", cavemarkStrategy))
 	deployCmd.Flags().StringVarP(&manualDeployKey, "deploy-key", "k", "", fmt.Sprintf("a manually specified deployment key, should not be used with strategy"))
 	deployCmd.Flags().BoolVarP(&watch, "watch", "w", false, "deploy when directory changes")
+	deployCmd.Flags().BoolVarP(&dryRun, "dry-run", "", false, fmt.Sprintf("print the files that would be deployed with their content types, without deploying [%s]", cavemarkDryRun))
+	deployCmd.Flags().StringVarP(&deployArtifact, "artifact", "", "", fmt.Sprintf("deploy an artifact created by the build command instead of the project directories [%s]", cavemarkArtifact))
+	deployCmd.Flags().BoolVarP(&typecheck, "typecheck", "", false, fmt.Sprintf("type check TypeScript functions before bundling [%s]", cavemarkTypecheck))
+	addBundleFlags(deployCmd.Flags())
+	addOpenAPIFlags(deployCmd.Flags())
+	addContentTypeFlags(deployCmd.Flags())
+	addPrecompressFlags(deployCmd.Flags())
+	addFingerprintFlags(deployCmd.Flags())
+	addHeadersFlags(deployCmd.Flags())
+	addRedirectsFlags(deployCmd.Flags())
+	addShowIgnoredFlag(deployCmd.Flags())
+	addValidateTemplateFlag(deployCmd.Flags())
+	addUploadFlags(deployCmd.Flags())
+	addBatchFlags(deployCmd.Flags())
+	addCheckFlags(deployCmd.Flags())
 	rootCmd.AddCommand(deployCmd)
 
-	funcDir = resolveStringFlag(funcDir, cavemarkFuncDir, "src")
-	resourceDir = resolveStringFlag(resourceDir, cavemarkResourceDir, defaultResourceDir)
-	staticDir = resolveStringFlag(staticDir, cavemarkStaticDir, defaultStaticDir)
 	strategy = resolveStringFlag(strategy, cavemarkStrategy, "bluegreen")
+	typecheck = resolveBoolFlag(typecheck, cavemarkTypecheck)
+	deployArtifact = resolveStringFlag(deployArtifact, cavemarkArtifact, "")
+	dryRun = resolveBoolFlag(dryRun, cavemarkDryRun)
 }
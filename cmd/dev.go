@@ -0,0 +1,273 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+var (
+	devPort   string
+	devDBPath string
+	devRecord string
+	devForce  bool
+
+	devLiveReload bool
+)
+
+const (
+	cavemarkDevPort   = "CAVEMARK_DEV_PORT"
+	cavemarkDevDB     = "CAVEMARK_DEV_DB"
+	cavemarkDevRecord = "CAVEMARK_DEV_RECORD"
+	cavemarkDevForce  = "CAVEMARK_DEV_FORCE"
+
+	cavemarkDevLiveReload = "CAVEMARK_DEV_LIVE_RELOAD"
+)
+
+const liveReloadPath = "/__cavemark/livereload"
+
+var devCmd = &cobra.Command{
+	Use:   "dev",
+	Short: "run functions locally",
+	Long: `Runs functions locally in an emulation of the Cavemark runtime.
+
+The function bundle is rebuilt whenever a file in the function, resource or static directory
+changes.
+
+Databases:
+namespace.v1.db(connStr) is backed by a local SQLite database. Connection strings that start
+with "sqlite:" name their own database file, every other connection string uses the database
+set with --db. Statements use ? for positional parameters, the same as in production.
+
+Mail:
+namespace.v1.mail doesn't connect to an SMTP server. Every message is saved to the mailbox
+directory, use "cavemark mail list" and "cavemark mail show" to read them.
+
+Validate, crypto and money:
+namespace.v1.validate, namespace.v1.crypto and namespace.v1.money behave the same as in
+production: passwords are hashed with PBKDF2 (HMAC-SHA512, 65536 iterations and a 512 bit key
+unless set) and amounts are formatted in the currency and number format of the locale.
+
+Static files:
+After router.useStatic() is called, requests that don't match a route are served from the static
+directory, the same way deployed static files are served, including the headers of the
+_headers file and the rules of the _redirects file. Files left out of deployments by
+.cavemarkignore files aren't served. These files are read again when they change, and static
+files are served with status 500 while the _headers or _redirects file is invalid. HTML pages reload in the
+browser when a file changes, unless --live-reload=false is set.
+
+Recording:
+With --record every request and its response is saved to a HAR file. Use "cavemark replay" to
+run the recorded requests again and compare the responses. An existing HAR file is only
+overwritten with --force.
+
+Examples:
+  # runs the functions in "src" at http://localhost:8080
+  cavemark dev
+
+  # records requests to requests.har
+  cavemark dev --record requests.har`,
+	Args: cobra.MaximumNArgs(0),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		p("cavemark", "version %s\n", cmd.Parent().Version)
+		rt := newDevRuntime(devDBPath, mailDir)
+		defer rt.close()
+		if devRecord != "" {
+			recorder, err := newHARRecorder(devRecord, cmd.Parent().Version, devForce)
+			if err != nil {
+				return err
+			}
+			rt.recorder = recorder
+			p("dev", "recording requests to %s\n", devRecord)
+		}
+		if devLiveReload {
+			rt.liveReload = newLiveReload()
+		}
+
+		reload := func() error {
+			rulesErr := rt.loadStaticRules()
+			p("dev", "bundling functions in '%s'\n", funcDir)
+			result, err := bundle()
+			if err != nil {
+				return err
+			}
+			err = rt.load(result.code)
+			if err != nil {
+				return err
+			}
+			if rt.liveReload != nil {
+				rt.liveReload.notify()
+			}
+			return rulesErr
+		}
+		err := reload()
+		if err != nil {
+			return err
+		}
+
+		server := &http.Server{Addr: ":" + devPort, Handler: rt}
+		go func() {
+			p("dev", "listening on http://localhost:%s\n", devPort)
+			err := server.ListenAndServe()
+			if err != nil && err != http.ErrServerClosed {
+				p("error", "%s\n", err)
+			}
+		}()
+		return startWatching(reload)
+	},
+}
+
+// ServeHTTP runs a request through the function bundle.
+func (rt *devRuntime) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	if rt.liveReload != nil && r.URL.Path == liveReloadPath {
+		rt.liveReload.ServeHTTP(w, r)
+		return
+	}
+	req, err := newRuntimeRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	res, err := rt.run(req)
+	if err != nil {
+		p("error", "%s %s: %s\n", r.Method, r.URL.Path, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	for key, values := range res.headers {
+		for _, value := range values {
+			w.Header().Add(key, value)
+		}
+	}
+	body := res.body
+	if rt.liveReload != nil && mediaType(res.headers.Get("Content-Type")) == "text/html" {
+		body = injectLiveReload(body)
+	}
+	w.WriteHeader(res.status)
+	_, _ = w.Write(body)
+	p("dev", "%s %s [%d]\n", r.Method, r.URL.Path, res.status)
+	if rt.recorder != nil {
+		err = rt.recorder.record(req, res, r.Header.Get("Content-Type"), start)
+		if err != nil {
+			p("error", "error recording request: %s\n", err)
+		}
+	}
+}
+
+// liveReload tells browsers to reload when the functions are rebuilt, using server-sent events.
+type liveReload struct {
+	mu      sync.Mutex
+	clients map[chan struct{}]bool
+}
+
+func newLiveReload() *liveReload {
+	return &liveReload{clients: make(map[chan struct{}]bool)}
+}
+
+func (l *liveReload) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+	client := make(chan struct{}, 1)
+	l.mu.Lock()
+	l.clients[client] = true
+	l.mu.Unlock()
+	defer func() {
+		l.mu.Lock()
+		delete(l.clients, client)
+		l.mu.Unlock()
+	}()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	select {
+	case <-client:
+		_, _ = fmt.Fprint(w, "event: reload\ndata: reload\n\n")
+		flusher.Flush()
+	case <-r.Context().Done():
+	}
+}
+
+func (l *liveReload) notify() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for client := range l.clients {
+		select {
+		case client <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// injectLiveReload adds the live reload script before the closing body tag of an HTML page.
+func injectLiveReload(body []byte) []byte {
+	script := fmt.Sprintf(`<script>new EventSource(%q).addEventListener("reload", function () { location.reload(); });</script>`, liveReloadPath)
+	i := bytes.LastIndex(bytes.ToLower(body), []byte("</body>"))
+	if i < 0 {
+		return append(body, script...)
+	}
+	result := make([]byte, 0, len(body)+len(script))
+	result = append(result, body[:i]...)
+	result = append(result, script...)
+	return append(result, body[i:]...)
+}
+
+func newRuntimeRequest(r *http.Request) (*runtimeRequest, error) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	req := &runtimeRequest{
+		method:  r.Method,
+		path:    r.URL.Path,
+		fullURL: fmt.Sprintf("http://%s%s", r.Host, r.URL.RequestURI()),
+		body:    string(body),
+		form:    make(map[string][]string),
+		query:   r.URL.Query(),
+	}
+	contentType := r.Header.Get("Content-Type")
+	if strings.HasPrefix(contentType, "application/x-www-form-urlencoded") || strings.HasPrefix(contentType, "multipart/form-data") {
+		r.Body = ioutil.NopCloser(strings.NewReader(req.body))
+		err = r.ParseMultipartForm(32 << 20)
+		if err != nil && err != http.ErrNotMultipart {
+			return nil, err
+		}
+		req.form = r.PostForm
+	}
+	return req, nil
+}
+
+func init() {
+	devCmd.Flags().StringVarP(&devPort, "port", "p", "", fmt.Sprintf("the port to listen on [%s]", cavemarkDevPort))
+	devCmd.Flags().StringVarP(&devRecord, "record", "", "", fmt.Sprintf("record requests and responses to this HAR file [%s]", cavemarkDevRecord))
+	devCmd.Flags().BoolVarP(&devForce, "force", "", false, fmt.Sprintf("overwrite the HAR file of --record when it exists [%s]", cavemarkDevForce))
+	devCmd.Flags().BoolVarP(&devLiveReload, "live-reload", "", true, fmt.Sprintf("reload HTML pages in the browser when a file changes [%s]", cavemarkDevLiveReload))
+	addDevDBFlag(devCmd.Flags())
+	addStaticRuleFlags(devCmd.Flags())
+	addMailDirFlag(devCmd.Flags())
+	addProjectDirFlags(devCmd.Flags())
+	addBundleFlags(devCmd.Flags())
+	rootCmd.AddCommand(devCmd)
+
+	devPort = resolveStringFlag(devPort, cavemarkDevPort, "8080")
+	devRecord = resolveStringFlag(devRecord, cavemarkDevRecord, "")
+	devForce = resolveBoolFlag(devForce, cavemarkDevForce)
+	devLiveReload = resolveBoolFlag(devLiveReload, cavemarkDevLiveReload)
+}
+
+func addDevDBFlag(flags *pflag.FlagSet) {
+	flags.StringVarP(&devDBPath, "db", "", "", fmt.Sprintf("the SQLite database used by namespace.v1.db [%s]", cavemarkDevDB))
+	devDBPath = resolveStringFlag(devDBPath, cavemarkDevDB, ".cavemark/dev.sqlite")
+}
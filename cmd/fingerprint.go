@@ -0,0 +1,324 @@
+package cmd
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/spf13/pflag"
+)
+
+var (
+	fingerprint bool
+)
+
+const (
+	cavemarkFingerprint = "CAVEMARK_FINGERPRINT"
+)
+
+const assetManifestName = "asset-manifest.json"
+
+// fingerprintExtensions are the static files that get a content hash in their name. HTML pages
+// keep their names because they are linked to directly.
+var fingerprintExtensions = map[string]bool{
+	".css": true, ".js": true, ".mjs": true,
+	".png": true, ".jpg": true, ".jpeg": true, ".gif": true, ".svg": true, ".webp": true, ".avif": true,
+	".woff": true, ".woff2": true, ".ttf": true, ".otf": true, ".eot": true,
+	".wasm": true,
+}
+
+var (
+	htmlRefPattern    = regexp.MustCompile(`(?i)\b(?:src|href|poster)\s*=\s*(?:"[^"]*"|'[^']*')`)
+	htmlSrcsetPattern = regexp.MustCompile(`(?i)\bsrcset\s*=\s*(?:"[^"]*"|'[^']*')`)
+	cssRefPattern     = regexp.MustCompile(`url\(\s*(?:"[^"]*"|'[^']*'|[^)'"\s]*)\s*\)|@import\s+(?:"[^"]*"|'[^']*')`)
+	jsRefPattern      = regexp.MustCompile(`"[^"\\\n]*"|'[^'\\\n]*'`)
+	quotedRefPattern  = regexp.MustCompile(`(?:"([^"]*)"|'([^']*)'|url\(\s*([^)'"\s]*)\s*\))`)
+)
+
+// assetFingerprints maps static files to names that contain a hash of their contents, so they
+// can be cached forever. HTML pages, CSS files, JavaScript files and templates are rewritten to
+// reference the fingerprinted names.
+type assetFingerprints struct {
+	// manifest maps the path of a static file to its fingerprinted path
+	manifest map[string]string
+	// rewritten holds the contents of static files whose references were rewritten
+	rewritten map[string][]byte
+}
+
+// fingerprintAssets fingerprints the files in the static directory. It returns nil without
+// --fingerprint.
+func fingerprintAssets() (*assetFingerprints, error) {
+	if !fingerprint || staticDir == "" {
+		return nil, nil
+	}
+	fp := &assetFingerprints{manifest: make(map[string]string), rewritten: make(map[string][]byte)}
+	_, err := os.Lstat(staticDir)
+	if err != nil {
+		if os.IsNotExist(err) && staticDir == defaultStaticDir {
+			return fp, nil
+		}
+		return nil, err
+	}
+	files, err := globAll(staticDir)
+	if err != nil {
+		return nil, fmt.Errorf("error globbing files: %w", err)
+	}
+	// only HTML pages, CSS and JavaScript files are read into memory, other files are hashed as
+	// a stream
+	contents := make(map[string][]byte)
+	pending := make([]string, 0)
+	for _, f := range files {
+		filePath := filepath.ToSlash(removeDir(f, staticDir))
+		ext := strings.ToLower(path.Ext(filePath))
+		switch {
+		case ext == ".css" || isScript(filePath) || isHTML(filePath):
+			data, err := ioutil.ReadFile(f)
+			if err != nil {
+				return nil, fmt.Errorf("error reading file (%s): %w", f, err)
+			}
+			contents[filePath] = data
+			if !isHTML(filePath) {
+				// CSS and JavaScript are fingerprinted after the images and fonts they reference
+				pending = append(pending, filePath)
+			}
+		case fingerprintExtensions[ext]:
+			hash, err := hashFile(f)
+			if err != nil {
+				return nil, err
+			}
+			fp.manifest[filePath] = fingerprintName(filePath, hash)
+		}
+	}
+	// CSS and JavaScript files are fingerprinted after the CSS and JavaScript files they
+	// reference, unless they reference each other
+	for len(pending) > 0 {
+		waiting := make([]string, 0, len(pending))
+		for _, filePath := range pending {
+			if referencesPending(filePath, contents[filePath], pending) {
+				waiting = append(waiting, filePath)
+				continue
+			}
+			fp.rewriteAsset(filePath, contents[filePath])
+		}
+		if len(waiting) == len(pending) {
+			for _, filePath := range waiting {
+				fp.rewriteAsset(filePath, contents[filePath])
+			}
+			break
+		}
+		pending = waiting
+	}
+	for filePath, data := range contents {
+		if isHTML(filePath) {
+			fp.rewritten[filePath] = fp.rewriteHTML(path.Dir(filePath), data)
+		}
+	}
+	return fp, nil
+}
+
+// fingerprintedPath inserts the first 8 hex characters of the SHA-256 of contents before the
+// extension, e.g. css/app.css becomes css/app.3f9a1c2b.css.
+func fingerprintedPath(filePath string, contents []byte) string {
+	hash := sha256.Sum256(contents)
+	return fingerprintName(filePath, hash[:])
+}
+
+func fingerprintName(filePath string, hash []byte) string {
+	ext := path.Ext(filePath)
+	return strings.TrimSuffix(filePath, ext) + "." + hex.EncodeToString(hash)[:8] + ext
+}
+
+// hashFile returns the SHA-256 of a file without reading it into memory.
+func hashFile(f string) ([]byte, error) {
+	file, err := os.Open(f)
+	if err != nil {
+		return nil, fmt.Errorf("error reading file (%s): %w", f, err)
+	}
+	defer func() { _ = file.Close() }()
+	hash := sha256.New()
+	_, err = io.Copy(hash, file)
+	if err != nil {
+		return nil, fmt.Errorf("error reading file (%s): %w", f, err)
+	}
+	return hash.Sum(nil), nil
+}
+
+// apply returns the path and contents to deploy for a resource or static file.
+func (fp *assetFingerprints) apply(kind, filePath string, body uploadBody) (string, uploadBody, error) {
+	if fp == nil {
+		return filePath, body, nil
+	}
+	switch kind {
+	case "static":
+		if rewritten, ok := fp.rewritten[filePath]; ok {
+			body = bytesBody(rewritten)
+		}
+		if fingerprinted, ok := fp.manifest[filePath]; ok {
+			filePath = fingerprinted
+		}
+	case "resource":
+		// templates are rendered for any path, so their references are resolved from the root
+		if isTemplate(filePath) {
+			contents, err := body.readAll()
+			if err != nil {
+				return "", body, err
+			}
+			body = bytesBody(fp.rewriteHTML("", contents))
+		}
+	}
+	return filePath, body, nil
+}
+
+// assetManifest returns the asset-manifest.json resource.
+func (fp *assetFingerprints) assetManifest() ([]byte, error) {
+	return json.MarshalIndent(fp.manifest, "", "  ")
+}
+
+func (fp *assetFingerprints) rewriteHTML(dir string, contents []byte) []byte {
+	contents = replaceRefs(htmlRefPattern, contents, func(ref string) string {
+		return fp.resolve(dir, ref)
+	})
+	return replaceRefs(htmlSrcsetPattern, contents, func(srcset string) string {
+		candidates := strings.Split(srcset, ",")
+		for i, candidate := range candidates {
+			fields := strings.Fields(candidate)
+			if len(fields) == 0 {
+				continue
+			}
+			candidates[i] = strings.Replace(candidate, fields[0], fp.resolve(dir, fields[0]), 1)
+		}
+		return strings.Join(candidates, ",")
+	})
+}
+
+// rewriteAsset rewrites the references of a CSS or JavaScript file and fingerprints the result.
+func (fp *assetFingerprints) rewriteAsset(filePath string, contents []byte) {
+	data := rewriteRefs(filePath, contents, func(ref string) string {
+		return fp.resolve(path.Dir(filePath), ref)
+	})
+	fp.rewritten[filePath] = data
+	fp.manifest[filePath] = fingerprintedPath(filePath, data)
+}
+
+// referencesPending reports whether a CSS or JavaScript file references another one of the
+// pending files.
+func referencesPending(filePath string, contents []byte, pending []string) bool {
+	found := false
+	rewriteRefs(filePath, contents, func(ref string) string {
+		refPath, ok := refFile(path.Dir(filePath), ref)
+		for _, p := range pending {
+			if ok && p == refPath && p != filePath {
+				found = true
+			}
+		}
+		return ref
+	})
+	return found
+}
+
+// rewriteRefs replaces the references of a CSS or JavaScript file. In JavaScript, only string
+// literals that are absolute paths or start with ./ or ../ are references, since any other
+// string could be anything. Relative references are resolved from the file, the way imports are.
+func rewriteRefs(filePath string, contents []byte, replace func(string) string) []byte {
+	if !isScript(filePath) {
+		return replaceRefs(cssRefPattern, contents, replace)
+	}
+	return replaceRefs(jsRefPattern, contents, func(ref string) string {
+		if !strings.HasPrefix(ref, "/") && !strings.HasPrefix(ref, "./") && !strings.HasPrefix(ref, "../") {
+			return ref
+		}
+		return replace(ref)
+	})
+}
+
+// resolve returns ref with the file name replaced by its fingerprinted name when ref points at
+// a fingerprinted file. Relative references are resolved from dir, absolute ones from the root.
+func (fp *assetFingerprints) resolve(dir, ref string) string {
+	filePath, ok := refFile(dir, ref)
+	if !ok {
+		return ref
+	}
+	fingerprinted, ok := fp.manifest[filePath]
+	if !ok {
+		return ref
+	}
+	refPath, suffix := ref, ""
+	if i := strings.IndexAny(ref, "?#"); i >= 0 {
+		refPath, suffix = ref[:i], ref[i:]
+	}
+	return strings.TrimSuffix(refPath, path.Base(refPath)) + path.Base(fingerprinted) + suffix
+}
+
+// refFile returns the path of the static file ref points at, or false for references to other
+// sites and fragments.
+func refFile(dir, ref string) (string, bool) {
+	if ref == "" || strings.HasPrefix(ref, "//") || strings.HasPrefix(ref, "#") || strings.Contains(strings.SplitN(ref, "/", 2)[0], ":") {
+		return "", false
+	}
+	refPath := ref
+	if i := strings.IndexAny(ref, "?#"); i >= 0 {
+		refPath = ref[:i]
+	}
+	if strings.HasPrefix(refPath, "/") {
+		return strings.TrimPrefix(path.Clean(refPath), "/"), true
+	}
+	return strings.TrimPrefix(path.Clean(path.Join("/", dir, refPath)), "/"), true
+}
+
+// replaceRefs replaces the quoted or url() reference in every match of pattern.
+func replaceRefs(pattern *regexp.Regexp, contents []byte, replace func(string) string) []byte {
+	return pattern.ReplaceAllFunc(contents, func(match []byte) []byte {
+		m := quotedRefPattern.FindSubmatchIndex(match)
+		if m == nil {
+			return match
+		}
+		for i := 2; i < len(m); i += 2 {
+			if m[i] < 0 {
+				continue
+			}
+			var buf bytes.Buffer
+			buf.Write(match[:m[i]])
+			buf.WriteString(replace(string(match[m[i]:m[i+1]])))
+			buf.Write(match[m[i+1]:])
+			return buf.Bytes()
+		}
+		return match
+	})
+}
+
+func isHTML(f string) bool {
+	ext := strings.ToLower(filepath.Ext(f))
+	return ext == ".html" || ext == ".htm"
+}
+
+func isScript(f string) bool {
+	ext := strings.ToLower(filepath.Ext(f))
+	return ext == ".js" || ext == ".mjs"
+}
+
+// deployAssetManifest uploads asset-manifest.json as a resource, unless fp is nil.
+func deployAssetManifest(deployKey string, fp *assetFingerprints) error {
+	if fp == nil {
+		return nil
+	}
+	manifest, err := fp.assetManifest()
+	if err != nil {
+		return err
+	}
+	p("resources", "deploying file %s (application/json)", assetManifestName)
+	return deployFile(deployKey, "resource", assetManifestName, "application/json", bytesBody(manifest))
+}
+
+func addFingerprintFlags(flags *pflag.FlagSet) {
+	flags.BoolVarP(&fingerprint, "fingerprint", "", false, fmt.Sprintf("add a content hash to the names of static assets and rewrite references to them [%s]", cavemarkFingerprint))
+	fingerprint = resolveBoolFlag(fingerprint, cavemarkFingerprint)
+}
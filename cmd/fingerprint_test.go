@@ -0,0 +1,64 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFingerprintAssets(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cavemark-fingerprint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+	files := map[string]string{
+		"img/logo.png": "png",
+		"css/app.css":  "body { background: url('../img/logo.png'); }",
+		"js/util.js":   "export const logo = '/img/logo.png';",
+		"js/app.js":    "import { logo } from \"./util.js\";\nconst other = 'img/logo.png';\nconst doc = \"don't\";\n",
+		"index.html":   "<link href=\"/css/app.css\"><script src=\"js/app.js\"></script>",
+	}
+	for name, contents := range files {
+		f := filepath.Join(dir, filepath.FromSlash(name))
+		err = os.MkdirAll(filepath.Dir(f), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = ioutil.WriteFile(f, []byte(contents), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	defer func(dir string, enabled bool) { staticDir, fingerprint = dir, enabled }(staticDir, fingerprint)
+	staticDir, fingerprint = dir, true
+
+	fp, err := fingerprintAssets()
+	if err != nil {
+		t.Fatal(err)
+	}
+	logo := fp.manifest["img/logo.png"]
+	util := fp.manifest["js/util.js"]
+	tests := []struct {
+		file string
+		want string
+	}{
+		{"css/app.css", "body { background: url('../img/" + filepath.Base(logo) + "'); }"},
+		{"js/util.js", "export const logo = '/" + logo + "';"},
+		// strings that aren't absolute or ./ and ../ paths are left alone
+		{"js/app.js", "import { logo } from \"./" + filepath.Base(util) + "\";\nconst other = 'img/logo.png';\nconst doc = \"don't\";\n"},
+		{"index.html", "<link href=\"/" + fp.manifest["css/app.css"] + "\"><script src=\"" + fp.manifest["js/app.js"] + "\"></script>"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			if got := string(fp.rewritten[tt.file]); got != tt.want {
+				t.Errorf("rewritten %s = %q, want %q", tt.file, got, tt.want)
+			}
+		})
+	}
+	// a file is fingerprinted with its rewritten contents, after the files it references
+	if want := fingerprintedPath("js/util.js", fp.rewritten["js/util.js"]); util != want {
+		t.Errorf("fingerprinted js/util.js = %s, want %s", util, want)
+	}
+}
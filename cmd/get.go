@@ -24,6 +24,10 @@ var getDeployKeyCmd = &cobra.Command{
 	Use:   "deploy-key",
 	Short: "returns the currently activated deployment key",
 	RunE: func(cmd *cobra.Command, args []string) error {
+		err := negotiate(cmd.Root().Version)
+		if err != nil {
+			return err
+		}
 		id, err := getDeployKey()
 		if err != nil {
 			return err
@@ -37,6 +41,10 @@ var getDeployListCmd = &cobra.Command{
 	Use:   "deploy-list",
 	Short: "returns a list of all deployments",
 	RunE: func(cmd *cobra.Command, args []string) error {
+		err := negotiate(cmd.Root().Version)
+		if err != nil {
+			return err
+		}
 		return printDeployList()
 	},
 }
@@ -48,7 +56,7 @@ type DeploymentSummary struct {
 }
 
 func printDeployList() error {
-	resp, err := httpGet(fmt.Sprintf("%s/cli/deploy/list", url))
+	resp, err := httpGet(apiURL("/deploy/list"))
 	if err != nil {
 		return err
 	}
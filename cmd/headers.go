@@ -0,0 +1,258 @@
+package cmd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/pflag"
+)
+
+var (
+	headersFile        string
+	defaultHeadersFile = "_headers"
+)
+
+const (
+	cavemarkHeadersFile = "CAVEMARK_HEADERS_FILE"
+)
+
+// staticHeaderPrefix is prepended to the name of every header of a static file when it is
+// uploaded, e.g. Cache-Control is sent as X-Cavemark-Header-Cache-Control.
+const staticHeaderPrefix = "X-Cavemark-Header-"
+
+// reservedHeaders are set by Cavemark itself and can't be set with header rules.
+var reservedHeaders = map[string]string{
+	"Content-Type":      "use --content-type instead",
+	"Content-Encoding":  "use --precompress instead",
+	"Content-Length":    "it is set from the file size",
+	"Transfer-Encoding": "it is set by Cavemark",
+	"Connection":        "it is set by Cavemark",
+	"Set-Cookie":        "static files can't set cookies",
+}
+
+// cacheControlDirectives are the Cache-Control response directives. The ones mapped to true
+// require a number of seconds.
+var cacheControlDirectives = map[string]bool{
+	"max-age": true, "s-maxage": true, "stale-while-revalidate": true, "stale-if-error": true,
+	"public": false, "private": false, "no-cache": false, "no-store": false, "no-transform": false,
+	"must-revalidate": false, "proxy-revalidate": false, "must-understand": false, "immutable": false,
+}
+
+var headerNamePattern = regexp.MustCompile("^[!#$%&'*+.^_`|~0-9A-Za-z-]+$")
+
+// headerRule sets headers on the static files whose path matches pattern.
+type headerRule struct {
+	pattern string
+	line    int
+	headers map[string]string
+}
+
+type headerRules []headerRule
+
+// checkHeaderSupport fails before a deployment begins when static files have headers and the
+// server doesn't support them. Such a server would keep the headers as metadata of the upload
+// without ever sending them.
+func checkHeaderSupport() error {
+	if server.supports(featureHeaders) {
+		return nil
+	}
+	if deployArtifact != "" {
+		r, err := openArtifact(deployArtifact)
+		if err != nil {
+			return err
+		}
+		defer func() { _ = r.Close() }()
+		for _, f := range r.manifest.Files {
+			if len(f.Headers) > 0 {
+				return fmt.Errorf("artifact (%s) has static files with headers, which the server doesn't support", deployArtifact)
+			}
+		}
+		return nil
+	}
+	rules, err := loadHeaderRules()
+	if err != nil {
+		return err
+	}
+	if len(rules) > 0 {
+		return fmt.Errorf("headers file (%s) sets headers of static files, which the server doesn't support", headersFile)
+	}
+	return nil
+}
+
+// loadHeaderRules reads and validates the header rules file. A missing default file means there
+// are no rules.
+func loadHeaderRules() (headerRules, error) {
+	if headersFile == "" {
+		return nil, nil
+	}
+	f, err := os.Open(headersFile)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) && headersFile == defaultHeadersFile {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("error reading headers file: %w", err)
+	}
+	defer func() { _ = f.Close() }()
+	return parseHeaderRules(headersFile, f)
+}
+
+// parseHeaderRules parses rules in the _headers format: a line with a path pattern followed by
+// indented "Name: value" lines. Blank lines and lines starting with # are ignored.
+func parseHeaderRules(name string, r io.Reader) (headerRules, error) {
+	rules := make(headerRules, 0)
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := scanner.Text()
+		trimmed := strings.TrimSpace(text)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		if text[0] != ' ' && text[0] != '\t' {
+			if !strings.HasPrefix(trimmed, "/") {
+				return nil, fmt.Errorf("%s:%d: path pattern (%s) must start with /", name, line, trimmed)
+			}
+			rules = append(rules, headerRule{pattern: trimmed, line: line, headers: make(map[string]string)})
+			continue
+		}
+		if len(rules) == 0 {
+			return nil, fmt.Errorf("%s:%d: header must follow a path pattern", name, line)
+		}
+		pair := strings.SplitN(trimmed, ":", 2)
+		if len(pair) != 2 {
+			return nil, fmt.Errorf("%s:%d: header (%s) must be in the form Name: value", name, line, trimmed)
+		}
+		key, value := strings.TrimSpace(pair[0]), strings.TrimSpace(pair[1])
+		err := validateHeader(key, value)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", name, line, err)
+		}
+		rules[len(rules)-1].headers[http.CanonicalHeaderKey(key)] = value
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading headers file: %w", err)
+	}
+	for _, rule := range rules {
+		if len(rule.headers) == 0 {
+			return nil, fmt.Errorf("%s:%d: path pattern (%s) has no headers", name, rule.line, rule.pattern)
+		}
+	}
+	return rules, nil
+}
+
+func validateHeader(key, value string) error {
+	if !headerNamePattern.MatchString(key) {
+		return fmt.Errorf("header name (%s) is invalid", key)
+	}
+	if reason, ok := reservedHeaders[http.CanonicalHeaderKey(key)]; ok {
+		return fmt.Errorf("header %s can't be set, %s", http.CanonicalHeaderKey(key), reason)
+	}
+	if value == "" {
+		return fmt.Errorf("header %s has no value", key)
+	}
+	for _, c := range value {
+		if c < ' ' && c != '\t' || c == 0x7f {
+			return fmt.Errorf("header %s contains a control character", key)
+		}
+	}
+	if http.CanonicalHeaderKey(key) == "Cache-Control" {
+		return validateCacheControl(value)
+	}
+	return nil
+}
+
+func validateCacheControl(value string) error {
+	for _, directive := range strings.Split(value, ",") {
+		pair := strings.SplitN(strings.TrimSpace(directive), "=", 2)
+		name := strings.ToLower(pair[0])
+		seconds, ok := cacheControlDirectives[name]
+		if !ok {
+			return fmt.Errorf("Cache-Control directive (%s) is unknown", pair[0])
+		}
+		if !seconds {
+			continue
+		}
+		if len(pair) != 2 {
+			return fmt.Errorf("Cache-Control directive %s requires a number of seconds", name)
+		}
+		_, err := strconv.ParseUint(pair[1], 10, 32)
+		if err != nil {
+			return fmt.Errorf("Cache-Control directive %s has an invalid number of seconds (%s)", name, pair[1])
+		}
+	}
+	return nil
+}
+
+// match returns the headers of a static file, given its path relative to the static directory.
+// The headers of all matching rules are combined, a later rule overrides a header set by an
+// earlier one.
+func (rules headerRules) match(filePath string) map[string]string {
+	var headers map[string]string
+	for _, rule := range rules {
+		if !matchPathPattern(rule.pattern, "/"+strings.TrimPrefix(filePath, "/")) {
+			continue
+		}
+		if headers == nil {
+			headers = make(map[string]string)
+		}
+		for key, value := range rule.headers {
+			headers[key] = value
+		}
+	}
+	return headers
+}
+
+// matchPathPattern reports whether a path matches a pattern in which * matches any sequence of
+// characters, including /.
+func matchPathPattern(pattern, filePath string) bool {
+	parts := strings.Split(pattern, "*")
+	if len(parts) == 1 {
+		return pattern == filePath
+	}
+	if !strings.HasPrefix(filePath, parts[0]) {
+		return false
+	}
+	filePath = filePath[len(parts[0]):]
+	last := parts[len(parts)-1]
+	for _, part := range parts[1 : len(parts)-1] {
+		i := strings.Index(filePath, part)
+		if i < 0 {
+			return false
+		}
+		filePath = filePath[i+len(part):]
+	}
+	return len(filePath) >= len(last) && strings.HasSuffix(filePath, last)
+}
+
+// uploadHeaders returns the request headers that send the headers of a static file to Cavemark.
+func uploadHeaders(headers map[string]string) http.Header {
+	result := make(http.Header)
+	for key, value := range headers {
+		result.Set(staticHeaderPrefix+key, value)
+	}
+	return result
+}
+
+// sortedHeaderNames returns the names of headers in alphabetical order.
+func sortedHeaderNames(headers map[string]string) []string {
+	names := make([]string, 0, len(headers))
+	for key := range headers {
+		names = append(names, key)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func addHeadersFlags(flags *pflag.FlagSet) {
+	flags.StringVarP(&headersFile, "headers-file", "", "", fmt.Sprintf("the file with the header rules for static files [%s]", cavemarkHeadersFile))
+	headersFile = resolveStringFlag(headersFile, cavemarkHeadersFile, defaultHeadersFile)
+}
@@ -0,0 +1,126 @@
+package cmd
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseHeaderRules(t *testing.T) {
+	tests := []struct {
+		name    string
+		src     string
+		want    headerRules
+		wantErr string
+	}{
+		{
+			name: "rules and comments",
+			src:  "# security\n/*\n  X-Content-Type-Options: nosniff\n\tx-frame-options: DENY\n\n/css/*\n  Cache-Control: public, max-age=31536000, immutable\n",
+			want: headerRules{
+				{pattern: "/*", line: 2, headers: map[string]string{"X-Content-Type-Options": "nosniff", "X-Frame-Options": "DENY"}},
+				{pattern: "/css/*", line: 6, headers: map[string]string{"Cache-Control": "public, max-age=31536000, immutable"}},
+			},
+		},
+		{
+			name: "value with a colon",
+			src:  "/index.html\n  Link: <https://cdn.example.com>; rel=preconnect\n",
+			want: headerRules{
+				{pattern: "/index.html", line: 1, headers: map[string]string{"Link": "<https://cdn.example.com>; rel=preconnect"}},
+			},
+		},
+		{
+			name:    "pattern without a slash",
+			src:     "*.css\n  Cache-Control: no-cache\n",
+			wantErr: "_headers:1: path pattern (*.css) must start with /",
+		},
+		{
+			name:    "header before a pattern",
+			src:     "  Cache-Control: no-cache\n",
+			wantErr: "_headers:1: header must follow a path pattern",
+		},
+		{
+			name:    "header without a value separator",
+			src:     "/*\n  X-Frame-Options DENY\n",
+			wantErr: "_headers:2: header (X-Frame-Options DENY) must be in the form Name: value",
+		},
+		{
+			name:    "invalid name",
+			src:     "/*\n  X Frame: DENY\n",
+			wantErr: "_headers:2: header name (X Frame) is invalid",
+		},
+		{
+			name:    "reserved header",
+			src:     "/*\n  content-type: text/plain\n",
+			wantErr: "_headers:2: header Content-Type can't be set, use --content-type instead",
+		},
+		{
+			name:    "empty value",
+			src:     "/*\n  X-Frame-Options:\n",
+			wantErr: "_headers:2: header X-Frame-Options has no value",
+		},
+		{
+			name:    "unknown Cache-Control directive",
+			src:     "/*\n  Cache-Control: forever\n",
+			wantErr: "_headers:2: Cache-Control directive (forever) is unknown",
+		},
+		{
+			name:    "Cache-Control directive without seconds",
+			src:     "/*\n  Cache-Control: max-age\n",
+			wantErr: "_headers:2: Cache-Control directive max-age requires a number of seconds",
+		},
+		{
+			name:    "Cache-Control directive with invalid seconds",
+			src:     "/*\n  Cache-Control: max-age=-1\n",
+			wantErr: "_headers:2: Cache-Control directive max-age has an invalid number of seconds (-1)",
+		},
+		{
+			name:    "pattern without headers",
+			src:     "/*\n/css/*\n  Cache-Control: no-cache\n",
+			wantErr: "_headers:1: path pattern (/*) has no headers",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseHeaderRules("_headers", strings.NewReader(tt.src))
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("parseHeaderRules() error = %v, want %s", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseHeaderRules() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHeaderRulesMatch(t *testing.T) {
+	rules, err := parseHeaderRules("_headers", strings.NewReader("/*\n  X-Frame-Options: DENY\n  Cache-Control: no-cache\n/css/*\n  Cache-Control: public, max-age=600\n/*.html\n  X-Robots-Tag: noindex\n/fonts/*/*.woff2\n  Access-Control-Allow-Origin: *\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		path string
+		want map[string]string
+	}{
+		{"index.html", map[string]string{"X-Frame-Options": "DENY", "Cache-Control": "no-cache", "X-Robots-Tag": "noindex"}},
+		{"/css/app.css", map[string]string{"X-Frame-Options": "DENY", "Cache-Control": "public, max-age=600"}},
+		{"docs/intro.html", map[string]string{"X-Frame-Options": "DENY", "Cache-Control": "no-cache", "X-Robots-Tag": "noindex"}},
+		{"fonts/inter/regular.woff2", map[string]string{"X-Frame-Options": "DENY", "Cache-Control": "no-cache", "Access-Control-Allow-Origin": "*"}},
+		{"fonts/regular.woff2", map[string]string{"X-Frame-Options": "DENY", "Cache-Control": "no-cache"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			if got := rules.match(tt.path); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("match(%s) = %v, want %v", tt.path, got, tt.want)
+			}
+		})
+	}
+	if got := headerRules(nil).match("index.html"); got != nil {
+		t.Errorf("match without rules = %v, want nil", got)
+	}
+}
@@ -0,0 +1,314 @@
+package cmd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/spf13/pflag"
+)
+
+var (
+	showIgnored bool
+)
+
+const (
+	cavemarkShowIgnored = "CAVEMARK_SHOW_IGNORED"
+)
+
+// ignoreFileName is the name of the files that list the files to leave out of deployments, in the
+// format of a .gitignore. The file in the working directory applies to the function, resource and
+// static directories, a file in one of their subdirectories applies to that subdirectory.
+const ignoreFileName = ".cavemarkignore"
+
+type ignorePattern struct {
+	source  string
+	negate  bool
+	dirOnly bool
+	re      *regexp.Regexp
+}
+
+// ignoreMatcher matches paths against the ignore files from its base directory down to the
+// directory of the path. Ignore files are read once, until reset.
+type ignoreMatcher struct {
+	mu    sync.Mutex
+	base  string
+	files map[string][]ignorePattern
+}
+
+// newIgnoreMatcher returns a matcher for the paths in dir. Ignore files are read from the working
+// directory down when dir is inside it, otherwise from dir down.
+func newIgnoreMatcher(dir string) (*ignoreMatcher, error) {
+	base, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+	if base == wd || strings.HasPrefix(base, wd+string(filepath.Separator)) {
+		base = wd
+	}
+	return &ignoreMatcher{base: base, files: make(map[string][]ignorePattern)}, nil
+}
+
+// ignored reports whether a file or directory is ignored, either by a pattern that matches it or
+// because one of its parent directories is ignored. It returns the pattern that ignored it.
+func (m *ignoreMatcher) ignored(p string, isDir bool) (bool, string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	abs, err := filepath.Abs(p)
+	if err != nil {
+		return false, "", err
+	}
+	rel, err := filepath.Rel(m.base, abs)
+	if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+		return false, "", err
+	}
+	segments := strings.Split(filepath.ToSlash(rel), "/")
+	for i := range segments {
+		last := i == len(segments)-1
+		ignored, source, err := m.match(filepath.Join(m.base, filepath.Join(segments[:i+1]...)), !last || isDir)
+		if err != nil || ignored {
+			return ignored, source, err
+		}
+	}
+	return false, "", nil
+}
+
+// reset forgets the ignore files read so far, so they are read again after they change.
+func (m *ignoreMatcher) reset() {
+	m.mu.Lock()
+	m.files = make(map[string][]ignorePattern)
+	m.mu.Unlock()
+}
+
+// match applies the patterns of the ignore files in the parent directories of abs. The last
+// matching pattern decides, and patterns in deeper ignore files come after the ones above them.
+func (m *ignoreMatcher) match(abs string, isDir bool) (bool, string, error) {
+	ignored, source := false, ""
+	for dir := m.base; ; {
+		patterns, err := m.patterns(dir)
+		if err != nil {
+			return false, "", err
+		}
+		rel, _ := filepath.Rel(dir, abs)
+		rel = filepath.ToSlash(rel)
+		for _, pattern := range patterns {
+			if pattern.dirOnly && !isDir {
+				continue
+			}
+			if pattern.re.MatchString(rel) {
+				ignored, source = !pattern.negate, pattern.source
+			}
+		}
+		next := strings.SplitN(rel, "/", 2)
+		if len(next) < 2 {
+			break
+		}
+		dir = filepath.Join(dir, next[0])
+	}
+	return ignored, source, nil
+}
+
+func (m *ignoreMatcher) patterns(dir string) ([]ignorePattern, error) {
+	if patterns, ok := m.files[dir]; ok {
+		return patterns, nil
+	}
+	name := filepath.Join(dir, ignoreFileName)
+	if wd, err := os.Getwd(); err == nil {
+		if rel, err := filepath.Rel(wd, name); err == nil && !strings.HasPrefix(rel, "..") {
+			name = rel
+		}
+	}
+	patterns, err := readIgnoreFile(name)
+	if err != nil {
+		return nil, err
+	}
+	m.files[dir] = patterns
+	return patterns, nil
+}
+
+func readIgnoreFile(name string) ([]ignorePattern, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("error reading ignore file: %w", err)
+	}
+	defer func() { _ = f.Close() }()
+	patterns := make([]ignorePattern, 0)
+	scanner := bufio.NewScanner(f)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimRight(scanner.Text(), " \t")
+		if strings.HasSuffix(text, "\\") {
+			text += " "
+		}
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		pattern, err := compileIgnorePattern(text)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", name, line, err)
+		}
+		pattern.source = fmt.Sprintf("%s:%d: %s", name, line, text)
+		patterns = append(patterns, pattern)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading ignore file: %w", err)
+	}
+	return patterns, nil
+}
+
+// compileIgnorePattern turns a .gitignore pattern into a regular expression for slash separated
+// paths relative to the directory of the ignore file.
+func compileIgnorePattern(text string) (ignorePattern, error) {
+	pattern := ignorePattern{}
+	if strings.HasPrefix(text, "!") {
+		pattern.negate = true
+		text = text[1:]
+	} else if strings.HasPrefix(text, "\\!") || strings.HasPrefix(text, "\\#") {
+		text = text[1:]
+	}
+	if strings.HasSuffix(text, "/") {
+		pattern.dirOnly = true
+		text = strings.TrimSuffix(text, "/")
+	}
+	// a pattern with a slash before its end only matches relative to the ignore file
+	anchored := strings.Contains(text, "/")
+	text = strings.TrimPrefix(text, "/")
+
+	var re strings.Builder
+	re.WriteString("^")
+	if !anchored {
+		re.WriteString("(?:.*/)?")
+	}
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case strings.HasPrefix(text[i:], "**/") && (i == 0 || text[i-1] == '/'):
+			re.WriteString("(?:.*/)?")
+			i += 2
+		case strings.HasPrefix(text[i:], "**") && i+2 == len(text) && (i == 0 || text[i-1] == '/'):
+			re.WriteString(".*")
+			i++
+		case c == '*':
+			re.WriteString("[^/]*")
+		case c == '?':
+			re.WriteString("[^/]")
+		case c == '[':
+			class, end, err := compileIgnoreClass(text, i)
+			if err != nil {
+				return pattern, err
+			}
+			re.WriteString(class)
+			i = end
+		case c == '\\' && i+1 < len(text):
+			i++
+			re.WriteString(regexp.QuoteMeta(string(text[i])))
+		default:
+			re.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	re.WriteString("$")
+	var err error
+	pattern.re, err = regexp.Compile(re.String())
+	if err != nil {
+		return pattern, fmt.Errorf("pattern (%s) is invalid: %w", text, err)
+	}
+	return pattern, nil
+}
+
+// compileIgnoreClass turns the character class that starts at text[start] into a regular
+// expression and returns the index of its closing ]. As in git, a ] right after the [ or the !
+// belongs to the class, a backslash escapes the next character and a negated class doesn't
+// match a /.
+func compileIgnoreClass(text string, start int) (string, int, error) {
+	var class strings.Builder
+	i := start + 1
+	negate := i < len(text) && (text[i] == '!' || text[i] == '^')
+	if negate {
+		i++
+	}
+	for first := i; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case c == ']' && i > first:
+			if negate {
+				return "[^/" + class.String() + "]", i, nil
+			}
+			return "[" + class.String() + "]", i, nil
+		case c == '\\' && i+1 < len(text):
+			i++
+			c = text[i]
+			if !unicode.IsLetter(rune(c)) && !unicode.IsDigit(rune(c)) {
+				class.WriteByte('\\')
+			}
+			class.WriteByte(c)
+		case c == ']' || c == '\\':
+			class.WriteByte('\\')
+			class.WriteByte(c)
+		default:
+			class.WriteByte(c)
+		}
+	}
+	return "", 0, fmt.Errorf("pattern (%s) has an unterminated character class", text)
+}
+
+// printIgnoredFiles prints the ignored files and directories of the function, resource and
+// static directories with the pattern that ignored them.
+func printIgnoredFiles() error {
+	for _, dir := range []string{funcDir, resourceDir, staticDir} {
+		if dir == "" {
+			continue
+		}
+		_, err := os.Lstat(dir)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return err
+		}
+		matcher, err := newIgnoreMatcher(dir)
+		if err != nil {
+			return err
+		}
+		err = filepath.Walk(dir, func(path string, f os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if path == dir || strings.HasPrefix(f.Name(), ".") {
+				return nil
+			}
+			ignored, source, err := matcher.ignored(path, f.IsDir())
+			if err != nil || !ignored {
+				return err
+			}
+			if f.IsDir() {
+				p("ignored", "%s/ (%s)\n", path, source)
+				return filepath.SkipDir
+			}
+			p("ignored", "%s (%s)\n", path, source)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func addShowIgnoredFlag(flags *pflag.FlagSet) {
+	flags.BoolVarP(&showIgnored, "show-ignored", "", false, fmt.Sprintf("print the files left out by %s files and the pattern that matched them [%s]", ignoreFileName, cavemarkShowIgnored))
+	showIgnored = resolveBoolFlag(showIgnored, cavemarkShowIgnored)
+}
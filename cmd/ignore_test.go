@@ -0,0 +1,99 @@
+package cmd
+
+import (
+	"testing"
+)
+
+func TestCompileIgnorePattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		path    string
+		isDir   bool
+		want    bool
+	}{
+		{"*.log", "debug.log", false, true},
+		{"*.log", "logs/debug.log", false, true},
+		{"*.log", "debug.log.txt", false, false},
+		{"/build", "build", true, true},
+		{"/build", "src/build", true, false},
+		{"docs/*.md", "docs/intro.md", false, true},
+		{"docs/*.md", "docs/guide/intro.md", false, false},
+		{"?.txt", "a.txt", false, true},
+		{"?.txt", "ab.txt", false, false},
+		{"tmp/", "tmp", true, true},
+		{"tmp/", "tmp", false, false},
+
+		// character classes
+		{"[abc].js", "b.js", false, true},
+		{"[abc].js", "d.js", false, false},
+		{"[a-c].js", "c.js", false, true},
+		{"[!a-c].js", "c.js", false, false},
+		{"[!a-c].js", "d.js", false, true},
+		{"[!a-c].js", "/.js", false, false},
+		{"file[0-9].txt", "file7.txt", false, true},
+		{`[\]].txt`, "].txt", false, true},
+		{`[]a].txt`, "].txt", false, true},
+		{`[!]a].txt`, "].txt", false, false},
+		{`[!]a].txt`, "b.txt", false, true},
+		{`[a\-z].txt`, "-.txt", false, true},
+		{`[a\-z].txt`, "m.txt", false, false},
+		{`[.]txt`, "atxt", false, false},
+		{`[.]txt`, ".txt", false, true},
+		{`[*]`, "*", false, true},
+		{`[*]`, "a", false, false},
+
+		// **
+		{"**/cache", "cache", true, true},
+		{"**/cache", "a/b/cache", true, true},
+		{"**/cache", "a/cachex", true, false},
+		{"logs/**", "logs/a/b.log", false, true},
+		{"logs/**", "logs", true, false},
+		{"a/**/b", "a/b", false, true},
+		{"a/**/b", "a/x/y/b", false, true},
+		{"a/**/b", "a/xb", false, false},
+		{"**", "anything/at/all", false, true},
+		{"foo**", "foobar", false, true},
+		{"foo**", "foo/bar", false, false},
+		{"a**/b", "ax/b", false, true},
+		{"a**/b", "a/x/b", false, false},
+
+		// escapes and negation
+		{`\#notes`, "#notes", false, true},
+		{`\!important`, "!important", false, true},
+		{`\*`, "*", false, true},
+		{`\*`, "a", false, false},
+		{"!keep.log", "keep.log", false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.pattern+" "+tt.path, func(t *testing.T) {
+			pattern, err := compileIgnorePattern(tt.pattern)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := pattern.re.MatchString(tt.path) && (!pattern.dirOnly || tt.isDir)
+			if got != tt.want {
+				t.Errorf("%s matches %s = %v, want %v (%s)", tt.pattern, tt.path, got, tt.want, pattern.re)
+			}
+		})
+	}
+}
+
+func TestCompileIgnorePatternErrors(t *testing.T) {
+	tests := []struct {
+		pattern string
+		wantErr string
+	}{
+		{"[abc", "pattern ([abc) has an unterminated character class"},
+		{"[]", "pattern ([]) has an unterminated character class"},
+		{"a[", "pattern (a[) has an unterminated character class"},
+		{"[z-a]", "pattern ([z-a]) is invalid: error parsing regexp: invalid character class range: `z-a`"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.pattern, func(t *testing.T) {
+			_, err := compileIgnorePattern(tt.pattern)
+			if err == nil || err.Error() != tt.wantErr {
+				t.Errorf("compileIgnorePattern() error = %v, want %s", err, tt.wantErr)
+			}
+		})
+	}
+}
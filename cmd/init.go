@@ -9,6 +9,10 @@ import (
 	"strings"
 )
 
+var (
+	initTypeScript bool
+)
+
 var initCmd = &cobra.Command{
 	Use:   "init",
 	Short: "Initialize a project",
@@ -16,11 +20,26 @@ var initCmd = &cobra.Command{
 
 Example:
   # initializes a Cavemark project, with common defaults, in the current directory.
-  cavemark init`,
+  cavemark init
+
+  # initializes a TypeScript Cavemark project in the current directory.
+  cavemark init --typescript`,
 	Args: cobra.MaximumNArgs(0),
 	RunE: func(cmd *cobra.Command, args []string) error {
 		printInitHeader(cmd.Parent().Version)
-		return replicateFS()
+		if !initTypeScript {
+			return replicateFS(initFS, "_init", nil)
+		}
+		overrides, err := overrideFiles(typeScriptFS, "_typescript")
+		if err != nil {
+			return err
+		}
+		overrides["src/index.js"] = true
+		err = replicateFS(initFS, "_init", overrides)
+		if err != nil {
+			return err
+		}
+		return replicateFS(typeScriptFS, "_typescript", nil)
 	},
 }
 
@@ -32,9 +51,26 @@ func printInitHeader(version string) {
 //go:embed _init/*
 var initFS embed.FS
 
-func replicateFS() error {
-	rootDir := "_init"
-	return fs.WalkDir(initFS, rootDir, func(path string, d fs.DirEntry, walkErr error) error {
+//go:embed _typescript/*
+var typeScriptFS embed.FS
+
+// overrideFiles returns the files in fsys that replace files of the same name in the default project.
+func overrideFiles(fsys embed.FS, rootDir string) (map[string]bool, error) {
+	files := make(map[string]bool)
+	err := fs.WalkDir(fsys, rootDir, func(path string, d fs.DirEntry, walkErr error) error {
+		if walkErr != nil {
+			return walkErr
+		}
+		if !d.IsDir() {
+			files[strings.Replace(path, rootDir+"/", "", 1)] = true
+		}
+		return nil
+	})
+	return files, err
+}
+
+func replicateFS(fsys embed.FS, rootDir string, skip map[string]bool) error {
+	return fs.WalkDir(fsys, rootDir, func(path string, d fs.DirEntry, walkErr error) error {
 		if walkErr != nil {
 			return walkErr
 		}
@@ -43,16 +79,23 @@ func replicateFS() error {
 		}
 
 		filePath := strings.Replace(path, rootDir+"/", "", 1)
+		if skip[filePath] {
+			return nil
+		}
 
 		if d.IsDir() {
+			_, err := os.Stat(filePath)
+			if err == nil {
+				return nil
+			}
 			p("cavemark", "creating directory: %s\n", filePath)
-			err := os.Mkdir(filePath, os.FileMode(0755))
+			err = os.Mkdir(filePath, os.FileMode(0755))
 			if err != nil {
 				return err
 			}
 		} else {
 			p("cavemark", "creating file: %s\n", filePath)
-			data, err := initFS.ReadFile(path)
+			data, err := fsys.ReadFile(path)
 			if err != nil {
 				return err
 			}
@@ -66,5 +109,6 @@ func replicateFS() error {
 }
 
 func init() {
+	initCmd.Flags().BoolVarP(&initTypeScript, "typescript", "t", false, "initialize a TypeScript project")
 	rootCmd.AddCommand(initCmd)
 }
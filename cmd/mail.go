@@ -0,0 +1,170 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+var (
+	mailDir string
+)
+
+const (
+	cavemarkDevMailbox = "CAVEMARK_DEV_MAILBOX"
+)
+
+var mailCmd = &cobra.Command{
+	Use:   "mail",
+	Short: "read mail sent while running locally",
+	Long: `Reads the mail sent by namespace.v1.mail while running "cavemark dev".
+
+Messages are stored in the mailbox directory as .eml files, which can also be opened with any
+mail client.
+
+Examples:
+  # lists captured messages
+  cavemark mail list
+
+  # prints a captured message
+  cavemark mail show 20220619T101530.000000000`,
+}
+
+var mailListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "lists captured messages",
+	Args:  cobra.MaximumNArgs(0),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return printMailList()
+	},
+}
+
+var mailShowCmd = &cobra.Command{
+	Use:   "show <id>",
+	Short: "prints a captured message",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return printMail(args[0])
+	},
+}
+
+var mailClearCmd = &cobra.Command{
+	Use:   "clear",
+	Short: "deletes all captured messages",
+	Args:  cobra.MaximumNArgs(0),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ids, err := mailIDs()
+		if err != nil {
+			return err
+		}
+		for _, id := range ids {
+			err = os.Remove(filepath.Join(mailDir, id+".eml"))
+			if err != nil {
+				return err
+			}
+		}
+		p("mail", "deleted %d messages\n", len(ids))
+		return nil
+	},
+}
+
+func mailIDs() ([]string, error) {
+	files, err := ioutil.ReadDir(mailDir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	ids := make([]string, 0)
+	for _, f := range files {
+		if !f.IsDir() && strings.HasSuffix(f.Name(), ".eml") {
+			ids = append(ids, strings.TrimSuffix(f.Name(), ".eml"))
+		}
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+func readMail(id string) (*mail.Message, error) {
+	f, err := os.Open(filepath.Join(mailDir, filepath.Base(id)+".eml"))
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+	msg, err := mail.ReadMessage(f)
+	if err != nil {
+		return nil, err
+	}
+	body, err := ioutil.ReadAll(msg.Body)
+	if err != nil {
+		return nil, err
+	}
+	msg.Body = strings.NewReader(string(body))
+	return msg, nil
+}
+
+func printMailList() error {
+	ids, err := mailIDs()
+	if err != nil {
+		return err
+	}
+	fmt.Println("ID                       \tDate                         \tTo\tSubject")
+	fmt.Println("-------------------------\t-----------------------------\t--\t-------")
+	for _, id := range ids {
+		msg, err := readMail(id)
+		if err != nil {
+			return fmt.Errorf("error reading message (%s): %w", id, err)
+		}
+		date, _ := msg.Header.Date()
+		fmt.Printf("%s\t%s\t%s\t%s\n", id, date.Format(time.RFC1123), msg.Header.Get("To"), decodeMailHeader(msg.Header.Get("Subject")))
+	}
+	return nil
+}
+
+func printMail(id string) error {
+	msg, err := readMail(id)
+	if err != nil {
+		return fmt.Errorf("error reading message (%s): %w", id, err)
+	}
+	for _, key := range []string{"Date", "From", "To", "Subject", "X-Cavemark-Server", "Content-Type"} {
+		fmt.Printf("%s: %s\n", key, decodeMailHeader(msg.Header.Get(key)))
+	}
+	body, err := ioutil.ReadAll(msg.Body)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("\n%s\n", body)
+	return nil
+}
+
+func decodeMailHeader(value string) string {
+	decoded, err := new(mime.WordDecoder).DecodeHeader(value)
+	if err != nil {
+		return value
+	}
+	return decoded
+}
+
+func addMailDirFlag(flags *pflag.FlagSet) {
+	flags.StringVarP(&mailDir, "mailbox", "", "", fmt.Sprintf("the directory that captures mail sent by namespace.v1.mail [%s]", cavemarkDevMailbox))
+	mailDir = resolveStringFlag(mailDir, cavemarkDevMailbox, ".cavemark/mail")
+}
+
+func init() {
+	mailCmd.AddCommand(mailListCmd)
+	mailCmd.AddCommand(mailShowCmd)
+	mailCmd.AddCommand(mailClearCmd)
+	addMailDirFlag(mailCmd.PersistentFlags())
+	rootCmd.AddCommand(mailCmd)
+}
@@ -0,0 +1,809 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/dop251/goja/ast"
+	"github.com/dop251/goja/file"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+var (
+	openAPIOutput  string
+	openAPIPath    string
+	openAPITitle   string
+	openAPIVersion string
+)
+
+const (
+	cavemarkOpenAPI        = "CAVEMARK_OPENAPI"
+	cavemarkOpenAPITitle   = "CAVEMARK_OPENAPI_TITLE"
+	cavemarkOpenAPIVersion = "CAVEMARK_OPENAPI_VERSION"
+)
+
+var openAPICmd = &cobra.Command{
+	Use:   "openapi",
+	Short: "generate an OpenAPI document from the function's routes",
+	Long: `Generates an OpenAPI 3 document from the routes the function registers with
+namespace.v1.router. Routes are recorded the same way as "cavemark routes" does.
+
+Routes can be documented with a JSDoc comment directly before the router call:
+
+  /**
+   * Creates a user.
+   * @tag users
+   * @form {string} name - the name of the user
+   * @form {string} [nickname]
+   * @response 201 {{id: number, name: string}} the created user
+   * @response 400 {string} the form is invalid
+   */
+  router.post('/users', (namespace) => { ... });
+
+Supported tags are @summary, @description, @tag, @deprecated, @param (path parameters),
+@query, @form, @body (a JSON request body) and @response. Types are string, number, integer,
+boolean, object, Date, arrays (string[]) and object types ({id: number, name?: string}).
+Names in brackets are optional.
+
+Fields checked with namespace.v1.validate in a route's handlers are added as query parameters,
+or form fields for POST, PUT and PATCH routes: isRequired makes them required, isBetween sets
+the length and isEmail the format. Handlers are read from the router calls in the source, as
+functions passed to the router or functions of the same file, and are never run.
+
+Documentation comments and validated fields are matched by the method and the literal path of
+the router call. A route registered with a path in a variable, a template with placeholders or
+under a prefix has no documentation in the document, and a warning is printed to stderr for it.
+
+Use "cavemark deploy --openapi openapi.json" to deploy the document as a static file.
+
+Examples:
+  # prints the document
+  cavemark openapi
+
+  # writes the document to openapi.json
+  cavemark openapi -o openapi.json`,
+	Args:         cobra.MaximumNArgs(0),
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		data, err := generateOpenAPI()
+		if err != nil {
+			return err
+		}
+		if openAPIOutput == "" {
+			fmt.Println(string(data))
+			return nil
+		}
+		return ioutil.WriteFile(openAPIOutput, append(data, '\n'), 0644)
+	},
+}
+
+type openAPIDocument struct {
+	OpenAPI string                                  `json:"openapi"`
+	Info    openAPIInfo                             `json:"info"`
+	Paths   map[string]map[string]*openAPIOperation `json:"paths"`
+}
+
+type openAPIInfo struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+type openAPIOperation struct {
+	Summary     string                      `json:"summary,omitempty"`
+	Description string                      `json:"description,omitempty"`
+	Tags        []string                    `json:"tags,omitempty"`
+	Deprecated  bool                        `json:"deprecated,omitempty"`
+	Parameters  []*openAPIParameter         `json:"parameters,omitempty"`
+	RequestBody *openAPIRequestBody         `json:"requestBody,omitempty"`
+	Responses   map[string]*openAPIResponse `json:"responses"`
+}
+
+type openAPIParameter struct {
+	Name        string         `json:"name"`
+	In          string         `json:"in"`
+	Description string         `json:"description,omitempty"`
+	Required    bool           `json:"required,omitempty"`
+	Schema      *openAPISchema `json:"schema"`
+}
+
+type openAPIRequestBody struct {
+	Description string                      `json:"description,omitempty"`
+	Required    bool                        `json:"required,omitempty"`
+	Content     map[string]openAPIMediaType `json:"content"`
+}
+
+type openAPIMediaType struct {
+	Schema *openAPISchema `json:"schema"`
+}
+
+type openAPIResponse struct {
+	Description string                      `json:"description"`
+	Content     map[string]openAPIMediaType `json:"content,omitempty"`
+}
+
+type openAPISchema struct {
+	Type        string                    `json:"type,omitempty"`
+	Format      string                    `json:"format,omitempty"`
+	Description string                    `json:"description,omitempty"`
+	Items       *openAPISchema            `json:"items,omitempty"`
+	Properties  map[string]*openAPISchema `json:"properties,omitempty"`
+	Required    []string                  `json:"required,omitempty"`
+	MinLength   *int                      `json:"minLength,omitempty"`
+	MaxLength   *int                      `json:"maxLength,omitempty"`
+}
+
+// generateOpenAPI bundles the function, records its routes and returns the OpenAPI document.
+func generateOpenAPI() ([]byte, error) {
+	result, err := bundle()
+	if err != nil {
+		return nil, err
+	}
+	// recording routes doesn't use the database and mailbox
+	rt := newDevRuntime("", "")
+	defer rt.close()
+	err = rt.load(result.code)
+	if err != nil {
+		return nil, err
+	}
+	router, err := rt.recordRoutes()
+	if err != nil {
+		return nil, err
+	}
+	docs, err := findRouteDocs(funcDir)
+	if err != nil {
+		return nil, fmt.Errorf("error reading route documentation: %w", err)
+	}
+	validated, err := findValidatedFields(funcDir)
+	if err != nil {
+		return nil, fmt.Errorf("error reading validated fields: %w", err)
+	}
+
+	title := openAPITitle
+	if title == "" {
+		wd, err := os.Getwd()
+		if err != nil {
+			return nil, err
+		}
+		title = filepath.Base(wd)
+	}
+	doc := openAPIDocument{
+		OpenAPI: "3.0.3",
+		Info:    openAPIInfo{Title: title, Version: openAPIVersion},
+		Paths:   make(map[string]map[string]*openAPIOperation),
+	}
+	documented := make(map[string]bool)
+	for i, route := range router.routes {
+		if routeProblem(router, i) != "" {
+			continue
+		}
+		key := route.method + " " + route.path
+		routeDoc, ok := docs[key]
+		if ok {
+			documented[key] = true
+		} else {
+			warnOpenAPI("route %s has no documentation comment in the source\n", key)
+		}
+		path := openAPIPathOf(route.path)
+		if doc.Paths[path] == nil {
+			doc.Paths[path] = make(map[string]*openAPIOperation)
+		}
+		doc.Paths[path][strings.ToLower(route.method)] = newOpenAPIOperation(route, routeDoc, validated[key])
+	}
+	undocumented := make([]string, 0)
+	for key := range docs {
+		if !documented[key] {
+			undocumented = append(undocumented, key)
+		}
+	}
+	sort.Strings(undocumented)
+	for _, key := range undocumented {
+		warnOpenAPI("documentation comment of %s matches no route, only routes registered with a literal path are documented\n", key)
+	}
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	err = encoder.Encode(doc)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.TrimSpace(buf.Bytes()), nil
+}
+
+// warnOpenAPI prints a warning to stderr, which keeps the document printed to stdout valid.
+func warnOpenAPI(msg string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, "%10s: %s", "WARNING", fmt.Sprintf(msg, args...))
+}
+
+// openAPIPathOf converts a route path such as /users/:id into /users/{id}.
+func openAPIPathOf(routePath string) string {
+	segments := strings.Split(routePath, "/")
+	for i, segment := range segments {
+		if strings.HasPrefix(segment, ":") {
+			segments[i] = "{" + segment[1:] + "}"
+		}
+	}
+	path := strings.Join(segments, "/")
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	return path
+}
+
+func newOpenAPIOperation(route runtimeRoute, doc *routeDoc, validated []*routeDocField) *openAPIOperation {
+	if doc == nil {
+		doc = &routeDoc{}
+	}
+	op := &openAPIOperation{
+		Summary:     doc.summary,
+		Description: doc.description,
+		Tags:        doc.tags,
+		Deprecated:  doc.deprecated,
+		Responses:   make(map[string]*openAPIResponse),
+	}
+
+	for _, segment := range strings.Split(route.path, "/") {
+		if !strings.HasPrefix(segment, ":") {
+			continue
+		}
+		param := &openAPIParameter{Name: segment[1:], In: "path", Required: true, Schema: &openAPISchema{Type: "string"}}
+		if field := doc.field("param", param.Name); field != nil {
+			param.Description = field.description
+			param.Schema = field.schema
+		}
+		op.Parameters = append(op.Parameters, param)
+	}
+
+	// fields checked with namespace.v1.validate are form fields for requests with a body
+	inferredKind := "query"
+	if route.method == "POST" || route.method == "PUT" || route.method == "PATCH" {
+		inferredKind = "form"
+	}
+	fields := doc.fields
+	for _, v := range validated {
+		if f := doc.field(inferredKind, v.name); f != nil {
+			f.required = f.required || v.required
+			mergeSchema(f.schema, v.schema)
+			continue
+		}
+		fields = append(fields, &routeDocField{kind: inferredKind, name: v.name, required: v.required, schema: v.schema})
+	}
+
+	var form *openAPISchema
+	for _, f := range fields {
+		switch f.kind {
+		case "query":
+			op.Parameters = append(op.Parameters, &openAPIParameter{Name: f.name, In: "query", Description: f.description, Required: f.required, Schema: f.schema})
+		case "form":
+			if form == nil {
+				form = &openAPISchema{Type: "object", Properties: make(map[string]*openAPISchema)}
+			}
+			schema := f.schema
+			if f.description != "" {
+				schema.Description = f.description
+			}
+			form.Properties[f.name] = schema
+			if f.required {
+				form.Required = append(form.Required, f.name)
+			}
+		}
+	}
+	switch {
+	case doc.body != nil:
+		op.RequestBody = &openAPIRequestBody{
+			Description: doc.body.description,
+			Required:    true,
+			Content:     map[string]openAPIMediaType{"application/json": {Schema: doc.body.schema}},
+		}
+	case form != nil:
+		op.RequestBody = &openAPIRequestBody{
+			Required: len(form.Required) > 0,
+			Content:  map[string]openAPIMediaType{"application/x-www-form-urlencoded": {Schema: form}},
+		}
+	}
+
+	for _, r := range doc.responses {
+		response := &openAPIResponse{Description: r.description}
+		if response.Description == "" {
+			response.Description = r.name
+		}
+		if r.schema != nil {
+			contentType := "application/json"
+			if r.schema.Type == "string" {
+				contentType = "text/plain"
+			}
+			response.Content = map[string]openAPIMediaType{contentType: {Schema: r.schema}}
+		}
+		op.Responses[r.name] = response
+	}
+	if len(op.Responses) == 0 {
+		op.Responses["200"] = &openAPIResponse{Description: "OK"}
+	}
+	return op
+}
+
+func mergeSchema(schema, inferred *openAPISchema) {
+	if schema.Format == "" {
+		schema.Format = inferred.Format
+	}
+	if schema.MinLength == nil {
+		schema.MinLength = inferred.MinLength
+	}
+	if schema.MaxLength == nil {
+		schema.MaxLength = inferred.MaxLength
+	}
+}
+
+// routeDoc is the documentation of a route, read from the JSDoc comment before the router call.
+type routeDoc struct {
+	summary     string
+	description string
+	tags        []string
+	deprecated  bool
+	fields      []*routeDocField
+	body        *routeDocField
+	responses   []*routeDocField
+}
+
+// routeDocField is a @param, @query, @form, @body or @response tag. The name of a response is
+// its status code.
+type routeDocField struct {
+	kind        string
+	name        string
+	required    bool
+	description string
+	schema      *openAPISchema
+}
+
+func (d *routeDoc) field(kind, name string) *routeDocField {
+	for _, f := range d.fields {
+		if f.kind == kind && f.name == name {
+			return f
+		}
+	}
+	return nil
+}
+
+var routeDocPattern = regexp.MustCompile("/\\*\\*((?:[^*]|\\*[^/])*)\\*/\\s*[\\w$.]*\\.(get|post|put|patch|delete|options)\\s*\\(\\s*(?:'([^']*)'|\"([^\"]*)\"|`([^`]*)`)")
+
+// findRouteDocs reads the JSDoc comments of routes in the source files of dir, keyed by method
+// and path.
+func findRouteDocs(dir string) (map[string]*routeDoc, error) {
+	docs := make(map[string]*routeDoc)
+	err := walkRouteSources(dir, func(path string, data []byte) error {
+		for _, m := range routeDocPattern.FindAllStringSubmatch(string(data), -1) {
+			routePath := m[3] + m[4] + m[5]
+			docs[strings.ToUpper(m[2])+" "+routePath] = parseRouteDoc(m[1])
+		}
+		return nil
+	})
+	return docs, err
+}
+
+// walkRouteSources calls fn with every source file of dir that can register routes, skipping
+// test files and node_modules.
+func walkRouteSources(dir string, fn func(path string, data []byte) error) error {
+	return filepath.Walk(dir, func(path string, f os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if f.IsDir() {
+			if path != dir && (f.Name() == "node_modules" || strings.HasPrefix(f.Name(), ".")) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		switch filepath.Ext(path) {
+		case ".js", ".mjs", ".ts", ".tsx":
+		default:
+			return nil
+		}
+		for _, suffix := range testFileSuffixes {
+			if strings.HasSuffix(path, suffix) {
+				return nil
+			}
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		return fn(path, data)
+	})
+}
+
+func parseRouteDoc(comment string) *routeDoc {
+	doc := &routeDoc{}
+	var text []string
+	var tags []string
+	for _, line := range strings.Split(comment, "\n") {
+		line = strings.TrimSpace(line)
+		line = strings.TrimSpace(strings.TrimPrefix(line, "*"))
+		switch {
+		case strings.HasPrefix(line, "@"):
+			tags = append(tags, line)
+		case len(tags) > 0 && line != "":
+			tags[len(tags)-1] += " " + line
+		case len(tags) == 0:
+			text = append(text, line)
+		}
+	}
+	description := strings.TrimSpace(strings.Join(text, "\n"))
+	if description != "" {
+		lines := strings.SplitN(description, "\n", 2)
+		doc.summary = lines[0]
+		if len(lines) > 1 {
+			doc.description = description
+		}
+	}
+
+	for _, tag := range tags {
+		name, rest := splitWord(tag[1:])
+		switch name {
+		case "summary":
+			doc.summary = rest
+		case "description":
+			doc.description = rest
+		case "tag":
+			doc.tags = append(doc.tags, rest)
+		case "deprecated":
+			doc.deprecated = true
+		case "param", "query", "form":
+			schema, rest := parseDocType(rest)
+			fieldName, description := splitWord(rest)
+			required := !strings.HasPrefix(fieldName, "[")
+			fieldName = strings.Trim(fieldName, "[]")
+			if i := strings.Index(fieldName, "="); i >= 0 {
+				fieldName = fieldName[:i]
+			}
+			doc.fields = append(doc.fields, &routeDocField{
+				kind:        name,
+				name:        fieldName,
+				required:    required || name == "param",
+				description: strings.TrimSpace(strings.TrimPrefix(description, "-")),
+				schema:      schema,
+			})
+		case "body":
+			schema, description := parseDocType(rest)
+			doc.body = &routeDocField{kind: name, description: strings.TrimSpace(strings.TrimPrefix(description, "-")), schema: schema}
+		case "response":
+			status, rest := splitWord(rest)
+			var schema *openAPISchema
+			if strings.HasPrefix(rest, "{") {
+				schema, rest = parseDocType(rest)
+			}
+			doc.responses = append(doc.responses, &routeDocField{kind: name, name: status, description: strings.TrimSpace(strings.TrimPrefix(rest, "-")), schema: schema})
+		}
+	}
+	return doc
+}
+
+func splitWord(s string) (string, string) {
+	s = strings.TrimSpace(s)
+	i := strings.IndexAny(s, " \t")
+	if i < 0 {
+		return s, ""
+	}
+	return s[:i], strings.TrimSpace(s[i:])
+}
+
+// parseDocType parses the {type} at the start of s and returns its schema and the rest of s.
+// A missing type is a string.
+func parseDocType(s string) (*openAPISchema, string) {
+	s = strings.TrimSpace(s)
+	if !strings.HasPrefix(s, "{") {
+		return &openAPISchema{Type: "string"}, s
+	}
+	depth := 0
+	for i, r := range s {
+		switch r {
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				return parseTypeExpression(s[1:i]), strings.TrimSpace(s[i+1:])
+			}
+		}
+	}
+	return &openAPISchema{Type: "string"}, s
+}
+
+// parseTypeExpression converts a JSDoc type such as string[] or {id: number, name?: string}
+// into a schema. Unknown types are allowed to be anything.
+func parseTypeExpression(expr string) *openAPISchema {
+	expr = strings.TrimSpace(expr)
+	if strings.HasSuffix(expr, "[]") {
+		return &openAPISchema{Type: "array", Items: parseTypeExpression(strings.TrimSuffix(expr, "[]"))}
+	}
+	if strings.HasPrefix(expr, "Array<") && strings.HasSuffix(expr, ">") {
+		return &openAPISchema{Type: "array", Items: parseTypeExpression(expr[6 : len(expr)-1])}
+	}
+	if strings.HasPrefix(expr, "{") && strings.HasSuffix(expr, "}") {
+		schema := &openAPISchema{Type: "object", Properties: make(map[string]*openAPISchema)}
+		for _, field := range splitTypeFields(expr[1 : len(expr)-1]) {
+			i := strings.Index(field, ":")
+			if i < 0 {
+				continue
+			}
+			name := strings.TrimSpace(field[:i])
+			optional := strings.HasSuffix(name, "?")
+			name = strings.TrimSuffix(name, "?")
+			schema.Properties[name] = parseTypeExpression(field[i+1:])
+			if !optional {
+				schema.Required = append(schema.Required, name)
+			}
+		}
+		return schema
+	}
+	switch strings.ToLower(expr) {
+	case "string":
+		return &openAPISchema{Type: "string"}
+	case "number":
+		return &openAPISchema{Type: "number"}
+	case "integer", "int":
+		return &openAPISchema{Type: "integer"}
+	case "boolean", "bool":
+		return &openAPISchema{Type: "boolean"}
+	case "object":
+		return &openAPISchema{Type: "object"}
+	case "date":
+		return &openAPISchema{Type: "string", Format: "date-time"}
+	default:
+		return &openAPISchema{}
+	}
+}
+
+// splitTypeFields splits the fields of an object type on commas and semicolons outside nested types.
+func splitTypeFields(s string) []string {
+	fields := make([]string, 0)
+	depth, start := 0, 0
+	for i, r := range s {
+		switch r {
+		case '{', '<':
+			depth++
+		case '}', '>':
+			depth--
+		case ',', ';':
+			if depth == 0 {
+				fields = append(fields, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	if strings.TrimSpace(s[start:]) != "" {
+		fields = append(fields, s[start:])
+	}
+	return fields
+}
+
+// findValidatedFields reads the fields the route handlers in the source files of dir check with
+// namespace.v1.validate, keyed by method and path.
+func findValidatedFields(dir string) (map[string][]*routeDocField, error) {
+	manifest, err := loadRuntimeAPI()
+	if err != nil {
+		return nil, err
+	}
+	fields := make(map[string][]*routeDocField)
+	err = walkRouteSources(dir, func(path string, data []byte) error {
+		for route, validated := range validatedFieldsOf(manifest, path, string(data)) {
+			fields[route] = append(fields[route], validated...)
+		}
+		return nil
+	})
+	return fields, err
+}
+
+// validatedFieldsOf parses the module at path and returns the fields each route checks with
+// namespace.v1.validate, such as validate.that('email', ...).isRequired().isEmail(). The
+// handlers of a route are the functions passed to the router call and the functions of the
+// module it names.
+func validatedFieldsOf(manifest apiManifest, path, src string) map[string][]*routeDocField {
+	fields := make(map[string][]*routeDocField)
+	program, err := parseModule(path, src)
+	if err != nil {
+		// syntax errors are reported when bundling
+		return fields
+	}
+	c := &apiChecker{
+		manifest:   manifest,
+		namespaces: make(map[string]bool),
+		bindings:   make(map[string]string),
+		problems:   make(map[file.Idx]string),
+	}
+	functions := make(map[string]ast.Expression)
+	routes := make([]*ast.CallExpression, 0)
+	walkJS(program, func(node ast.Node) {
+		c.visit(node)
+		switch n := node.(type) {
+		case *ast.FunctionDeclaration:
+			if n.Function.Name != nil {
+				functions[n.Function.Name.Name.String()] = n.Function
+			}
+		case *ast.Binding:
+			target, ok := n.Target.(*ast.Identifier)
+			if !ok {
+				return
+			}
+			switch n.Initializer.(type) {
+			case *ast.FunctionLiteral, *ast.ArrowFunctionLiteral:
+				functions[target.Name.String()] = n.Initializer
+			}
+		case *ast.CallExpression:
+			callee, ok := n.Callee.(*ast.DotExpression)
+			if !ok || len(n.ArgumentList) < 2 || c.typeOf(callee.Left) != "Router" {
+				return
+			}
+			if _, ok := n.ArgumentList[0].(*ast.StringLiteral); ok {
+				routes = append(routes, n)
+			}
+		}
+	})
+	for _, call := range routes {
+		method := strings.ToUpper(call.Callee.(*ast.DotExpression).Identifier.Name.String())
+		route := method + " " + call.ArgumentList[0].(*ast.StringLiteral).Value.String()
+		for _, handler := range call.ArgumentList[1:] {
+			if name, ok := handler.(*ast.Identifier); ok {
+				handler = functions[name.Name.String()]
+			}
+			if handler == nil {
+				continue
+			}
+			// handlers declared before the route get their namespace parameter only now
+			c.addNamespaceParameter(handler)
+			walkJS(handler, c.visit)
+			fields[route] = append(fields[route], validateChainFields(c, handler)...)
+		}
+	}
+	return fields
+}
+
+// validateChainFields returns the fields checked by the chains of validate calls in fn.
+func validateChainFields(c *apiChecker, fn ast.Node) []*routeDocField {
+	fields := make([]*routeDocField, 0)
+	chained := make(map[*ast.CallExpression]bool)
+	walkJS(fn, func(node ast.Node) {
+		call, ok := node.(*ast.CallExpression)
+		if !ok || chained[call] {
+			return
+		}
+		// the calls of the chain from the last to the first
+		calls := make([]*ast.CallExpression, 0)
+		var base ast.Expression = call
+		for {
+			next, ok := base.(*ast.CallExpression)
+			if !ok {
+				break
+			}
+			callee, ok := next.Callee.(*ast.DotExpression)
+			if !ok {
+				break
+			}
+			calls = append(calls, next)
+			base = callee.Left
+		}
+		if len(calls) == 0 || c.typeOf(base) != "Validate" {
+			return
+		}
+		var field *routeDocField
+		for i := len(calls) - 1; i >= 0; i-- {
+			chained[calls[i]] = true
+			args := calls[i].ArgumentList
+			switch calls[i].Callee.(*ast.DotExpression).Identifier.Name.String() {
+			case "that":
+				field = nil
+				if len(args) == 0 {
+					continue
+				}
+				if key, ok := args[0].(*ast.StringLiteral); ok {
+					field = &routeDocField{name: key.Value.String(), schema: &openAPISchema{Type: "string"}}
+					fields = append(fields, field)
+				}
+			case "isRequired":
+				if field != nil {
+					field.required = true
+				}
+			case "isEmail":
+				if field != nil {
+					field.schema.Format = "email"
+				}
+			case "isBetween":
+				if field == nil || len(args) < 2 {
+					continue
+				}
+				min, minOK := intLiteral(args[0])
+				max, maxOK := intLiteral(args[1])
+				if minOK && maxOK {
+					field.schema.MinLength = &min
+					field.schema.MaxLength = &max
+				}
+			}
+		}
+	})
+	return fields
+}
+
+func intLiteral(expr ast.Expression) (int, bool) {
+	n, ok := expr.(*ast.NumberLiteral)
+	if !ok {
+		return 0, false
+	}
+	switch v := n.Value.(type) {
+	case int64:
+		return int(v), true
+	case float64:
+		return int(v), true
+	}
+	return 0, false
+}
+
+// deployOpenAPI generates the OpenAPI document and deploys it as a static file.
+func deployOpenAPI(deployKey string) error {
+	if openAPIPath == "" {
+		return nil
+	}
+	indexExists, err := indexFunctionExists()
+	if err != nil || !indexExists {
+		return err
+	}
+	p("statics", "generating OpenAPI document")
+	data, err := generateOpenAPI()
+	if err != nil {
+		return fmt.Errorf("error generating OpenAPI document: %w", err)
+	}
+	p("", " [OK]\n")
+	rules, err := loadHeaderRules()
+	if err != nil {
+		return err
+	}
+	filePath := strings.TrimPrefix(openAPIPath, "/")
+	p("statics", "deploying file %s (application/json)", openAPIPath)
+	return deployStaticFile(deployKey, filePath, "application/json", rules.match(filePath), bytesBody(data), newPrecompressStats())
+}
+
+// addArtifactOpenAPI generates the OpenAPI document and adds it to the artifact as a static file.
+func addArtifactOpenAPI(w *artifactWriter) error {
+	if openAPIPath == "" {
+		return nil
+	}
+	indexExists, err := indexFunctionExists()
+	if err != nil || !indexExists {
+		return err
+	}
+	p("statics", "adding OpenAPI document %s\n", openAPIPath)
+	data, err := generateOpenAPI()
+	if err != nil {
+		return fmt.Errorf("error generating OpenAPI document: %w", err)
+	}
+	filePath := strings.TrimPrefix(openAPIPath, "/")
+	return w.addWithHeaders("static", filePath, "application/json", w.headerRules.match(filePath), data)
+}
+
+// addOpenAPIFlags adds the flags for deploying the OpenAPI document as a static file.
+func addOpenAPIFlags(flags *pflag.FlagSet) {
+	flags.StringVarP(&openAPIPath, "openapi", "", "", fmt.Sprintf("generate an OpenAPI document and deploy it as a static file with this path, e.g. openapi.json [%s]", cavemarkOpenAPI))
+	addOpenAPIInfoFlags(flags)
+	openAPIPath = resolveStringFlag(openAPIPath, cavemarkOpenAPI, "")
+}
+
+func addOpenAPIInfoFlags(flags *pflag.FlagSet) {
+	flags.StringVarP(&openAPITitle, "openapi-title", "", "", fmt.Sprintf("the title of the OpenAPI document, defaults to the project directory name [%s]", cavemarkOpenAPITitle))
+	flags.StringVarP(&openAPIVersion, "openapi-version", "", "", fmt.Sprintf("the version of the OpenAPI document [%s]", cavemarkOpenAPIVersion))
+	openAPITitle = resolveStringFlag(openAPITitle, cavemarkOpenAPITitle, "")
+	openAPIVersion = resolveStringFlag(openAPIVersion, cavemarkOpenAPIVersion, "1.0.0")
+}
+
+func init() {
+	openAPICmd.Flags().StringVarP(&openAPIOutput, "output", "o", "", "the file to write the document to, prints the document when empty")
+	addOpenAPIInfoFlags(openAPICmd.Flags())
+	addProjectDirFlags(openAPICmd.Flags())
+	addBundleFlags(openAPICmd.Flags())
+	rootCmd.AddCommand(openAPICmd)
+}
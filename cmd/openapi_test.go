@@ -0,0 +1,73 @@
+package cmd
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestValidatedFieldsOf(t *testing.T) {
+	manifest, err := loadRuntimeAPI()
+	if err != nil {
+		t.Fatal(err)
+	}
+	three, twenty := 3, 20
+	tests := []struct {
+		name string
+		path string
+		src  string
+		want map[string][]*routeDocField
+	}{
+		{
+			name: "inline handler",
+			path: "index.js",
+			src:  "function main(namespace) {\n  namespace.v1.router.get('/search', (ns) => {\n    ns.v1.validate.that('q', '').isRequired().check();\n  });\n}\n",
+			want: map[string][]*routeDocField{
+				"GET /search": {{name: "q", required: true, schema: &openAPISchema{Type: "string"}}},
+			},
+		},
+		{
+			name: "named handler and chained fields",
+			path: "index.js",
+			src:  "function create(ns) {\n  const v = ns.v1.validate;\n  v.that('email', '').isRequired().isEmail().that('name', '').isBetween(3, 20).check();\n}\nfunction main(namespace) {\n  const { router } = namespace.v1;\n  router.post('/users', create);\n}\n",
+			want: map[string][]*routeDocField{
+				"POST /users": {
+					{name: "email", required: true, schema: &openAPISchema{Type: "string", Format: "email"}},
+					{name: "name", schema: &openAPISchema{Type: "string", MinLength: &three, MaxLength: &twenty}},
+				},
+			},
+		},
+		{
+			name: "that of another object",
+			path: "index.js",
+			src:  "const chai = { that: () => chai, isRequired: () => chai };\nfunction main(namespace) {\n  namespace.v1.router.get('/', (ns) => { chai.that('x').isRequired(); });\n}\n",
+			want: map[string][]*routeDocField{},
+		},
+		{
+			name: "typescript",
+			path: "index.ts",
+			src:  "export const main = (namespace: namespace): void => {\n  namespace.v1.router.put('/users/:id', (ns: namespace) => {\n    ns.v1.validate.that('name', '').isRequired().check();\n  });\n};\n",
+			want: map[string][]*routeDocField{
+				"PUT /users/:id": {{name: "name", required: true, schema: &openAPISchema{Type: "string"}}},
+			},
+		},
+		{
+			name: "syntax error",
+			path: "index.js",
+			src:  "function main(namespace) {\n",
+			want: map[string][]*routeDocField{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := validatedFieldsOf(manifest, tt.path, tt.src)
+			for route, fields := range got {
+				if len(fields) == 0 {
+					delete(got, route)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("validatedFieldsOf() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
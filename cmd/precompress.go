@@ -0,0 +1,195 @@
+package cmd
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/spf13/pflag"
+)
+
+var (
+	precompress        bool
+	precompressMinSize string
+)
+
+const (
+	cavemarkPrecompress        = "CAVEMARK_PRECOMPRESS"
+	cavemarkPrecompressMinSize = "CAVEMARK_PRECOMPRESS_MIN_SIZE"
+)
+
+// precompressEncodings are the variants uploaded next to a static file. A variant is uploaded
+// with the file's path plus the extension and the encoding as its Content-Encoding.
+var precompressEncodings = []struct {
+	encoding string
+	ext      string
+	compress func(io.Writer) (io.WriteCloser, error)
+}{
+	{"br", ".br", compressBrotli},
+	{"gzip", ".gz", compressGzip},
+}
+
+// compressedVariant is a compressed copy of a static file, kept in a temporary file so large
+// files aren't held in memory.
+type compressedVariant struct {
+	encoding string
+	ext      string
+	file     string
+	body     uploadBody
+}
+
+// precompressStats adds up the bytes saved by the variants of a deployment.
+type precompressStats struct {
+	files int
+	saved map[string]int64
+}
+
+func newPrecompressStats() *precompressStats {
+	return &precompressStats{saved: make(map[string]int64)}
+}
+
+func (s *precompressStats) print() {
+	if s.files == 0 {
+		return
+	}
+	savings := make([]string, 0, len(precompressEncodings))
+	for _, e := range precompressEncodings {
+		savings = append(savings, fmt.Sprintf("%s with %s", formatByteSize(s.saved[e.encoding]), e.encoding))
+	}
+	p("statics", "precompressed %d files, saving %s\n", s.files, strings.Join(savings, " and "))
+}
+
+// deployStaticFile uploads a static file with its headers and, with --precompress, its compressed
+// variants.
+func deployStaticFile(deployKey, filePath, contentType string, headers map[string]string, body uploadBody, stats *precompressStats) error {
+	err := deployFileWithHeaders(deployKey, "static", filePath, contentType, uploadHeaders(headers), body)
+	if err != nil {
+		return err
+	}
+	variants, err := precompressVariants(contentType, body)
+	defer removeVariants(variants)
+	if err != nil {
+		return err
+	}
+	if len(variants) > 0 {
+		stats.files++
+	}
+	for _, v := range variants {
+		saved := body.size - v.body.size
+		stats.saved[v.encoding] += saved
+		p("statics", "deploying file %s (%s, %s, saves %s)", filePath+v.ext, contentType, v.encoding, formatByteSize(saved))
+		variantHeaders := uploadHeaders(headers)
+		variantHeaders.Set("Content-Encoding", v.encoding)
+		err = deployFileWithHeaders(deployKey, "static", filePath+v.ext, contentType, variantHeaders, v.body)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// precompressVariants returns the compressed variants of a file that are smaller than the file.
+// Nothing is compressed without --precompress, for files below the minimum size or for formats
+// that are already compressed. The variants must be removed with removeVariants.
+func precompressVariants(contentType string, body uploadBody) ([]compressedVariant, error) {
+	if !precompress || !isCompressible(contentType) {
+		return nil, nil
+	}
+	minSize, err := parseByteSize(precompressMinSize)
+	if err != nil {
+		return nil, err
+	}
+	if body.size < minSize {
+		return nil, nil
+	}
+	variants := make([]compressedVariant, 0, len(precompressEncodings))
+	for _, e := range precompressEncodings {
+		file, err := compressToFile(body, e.compress)
+		if err != nil {
+			return variants, fmt.Errorf("error compressing with %s: %w", e.encoding, err)
+		}
+		compressed, err := fileBody(file)
+		if err != nil {
+			_ = os.Remove(file)
+			return variants, err
+		}
+		if compressed.size >= body.size {
+			_ = os.Remove(file)
+			continue
+		}
+		// the temporary file changes every time, the contents it was compressed from don't
+		compressed.id = body.id + ":" + e.encoding
+		variants = append(variants, compressedVariant{encoding: e.encoding, ext: e.ext, file: file, body: compressed})
+	}
+	return variants, nil
+}
+
+func removeVariants(variants []compressedVariant) {
+	for _, v := range variants {
+		_ = os.Remove(v.file)
+	}
+}
+
+// compressToFile compresses a body into a temporary file and returns its name.
+func compressToFile(body uploadBody, compress func(io.Writer) (io.WriteCloser, error)) (string, error) {
+	tmp, err := ioutil.TempFile("", "cavemark-precompress-*")
+	if err != nil {
+		return "", err
+	}
+	err = func() error {
+		defer func() { _ = tmp.Close() }()
+		rc, err := body.open(0)
+		if err != nil {
+			return err
+		}
+		defer func() { _ = rc.Close() }()
+		w, err := compress(tmp)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(w, rc)
+		if err != nil {
+			return err
+		}
+		return w.Close()
+	}()
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+		return "", err
+	}
+	return tmp.Name(), nil
+}
+
+// isCompressible reports whether a content type benefits from compression. Images other than
+// SVG, fonts like WOFF2, archives and media are already compressed.
+func isCompressible(contentType string) bool {
+	t := mediaType(contentType)
+	if strings.HasPrefix(t, "text/") || strings.HasSuffix(t, "+json") || strings.HasSuffix(t, "+xml") {
+		return true
+	}
+	switch t {
+	case "application/json", "application/javascript", "application/xml", "application/wasm",
+		"image/x-icon", "font/ttf", "font/otf", "application/vnd.ms-fontobject":
+		return true
+	}
+	return false
+}
+
+func compressGzip(w io.Writer) (io.WriteCloser, error) {
+	return gzip.NewWriterLevel(w, gzip.BestCompression)
+}
+
+func compressBrotli(w io.Writer) (io.WriteCloser, error) {
+	return brotli.NewWriterLevel(w, brotli.BestCompression), nil
+}
+
+func addPrecompressFlags(flags *pflag.FlagSet) {
+	flags.BoolVarP(&precompress, "precompress", "", false, fmt.Sprintf("upload gzip and brotli variants of compressible static files [%s]", cavemarkPrecompress))
+	flags.StringVarP(&precompressMinSize, "precompress-min-size", "", "", fmt.Sprintf("the minimum size of static files to precompress, e.g. 1KB [%s]", cavemarkPrecompressMinSize))
+	precompress = resolveBoolFlag(precompress, cavemarkPrecompress)
+	precompressMinSize = resolveStringFlag(precompressMinSize, cavemarkPrecompressMinSize, "1KB")
+}
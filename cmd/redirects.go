@@ -0,0 +1,412 @@
+package cmd
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/pflag"
+)
+
+var (
+	redirectsFile        string
+	defaultRedirectsFile = "_redirects"
+)
+
+const (
+	cavemarkRedirectsFile = "CAVEMARK_REDIRECTS_FILE"
+)
+
+// redirectsManifestName is the static file the parsed redirect rules are deployed as.
+const redirectsManifestName = "_redirects.json"
+
+var redirectPlaceholderPattern = regexp.MustCompile(`:([A-Za-z_][A-Za-z0-9_]*)`)
+
+// redirectRule redirects or rewrites the requests whose path matches From. From may contain
+// :name placeholders for a single segment and a trailing * for the rest of the path, which To
+// can use as :name and :splat.
+type redirectRule struct {
+	From   string `json:"from"`
+	To     string `json:"to"`
+	Status int    `json:"status"`
+	// Force applies the rule even when a static file exists for the request path
+	Force bool `json:"force,omitempty"`
+	line  int
+}
+
+// isRedirect reports whether the rule redirects the browser, as opposed to serving another
+// static file under the request path.
+func (r redirectRule) isRedirect() bool {
+	return r.Status >= 300 && r.Status < 400
+}
+
+type redirectRules struct {
+	// TrailingSlash is "add" or "remove" to redirect paths to the same path with or without a
+	// trailing slash, or "" to leave them alone
+	TrailingSlash string         `json:"trailingSlash,omitempty"`
+	Rules         []redirectRule `json:"rules"`
+}
+
+// loadRedirectRules reads and validates the redirect rules file. A missing default file means
+// there are no rules.
+func loadRedirectRules() (*redirectRules, error) {
+	if redirectsFile == "" {
+		return nil, nil
+	}
+	f, err := os.Open(redirectsFile)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) && redirectsFile == defaultRedirectsFile {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("error reading redirects file: %w", err)
+	}
+	defer func() { _ = f.Close() }()
+	rules, err := parseRedirectRules(redirectsFile, f)
+	if err != nil {
+		return nil, err
+	}
+	err = rules.validate(redirectsFile)
+	if err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+// parseRedirectRules parses rules in the _redirects format: one "from to [status][!]" rule per
+// line, or a "trailing-slash add|remove" line. Blank lines and lines starting with # are ignored.
+func parseRedirectRules(name string, r io.Reader) (*redirectRules, error) {
+	rules := &redirectRules{Rules: make([]redirectRule, 0)}
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if fields[0] == "trailing-slash" {
+			if len(fields) != 2 || (fields[1] != "add" && fields[1] != "remove") {
+				return nil, fmt.Errorf("%s:%d: trailing-slash must be followed by add or remove", name, line)
+			}
+			rules.TrailingSlash = fields[1]
+			continue
+		}
+		if len(fields) < 2 || len(fields) > 3 {
+			return nil, fmt.Errorf("%s:%d: rule must be in the form from to [status]", name, line)
+		}
+		rule := redirectRule{From: fields[0], To: fields[1], Status: http.StatusMovedPermanently, line: line}
+		if len(fields) == 3 {
+			status := fields[2]
+			if strings.HasSuffix(status, "!") {
+				rule.Force = true
+				status = strings.TrimSuffix(status, "!")
+			}
+			var err error
+			rule.Status, err = strconv.Atoi(status)
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: status (%s) is not a number", name, line, fields[2])
+			}
+		}
+		rules.Rules = append(rules.Rules, rule)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading redirects file: %w", err)
+	}
+	return rules, nil
+}
+
+// validate checks every rule on its own, then reports rules that can never be matched and
+// redirects that never end.
+func (rules *redirectRules) validate(name string) error {
+	for _, rule := range rules.Rules {
+		err := validateRedirectRule(rule)
+		if err != nil {
+			return fmt.Errorf("%s:%d: %w", name, rule.line, err)
+		}
+	}
+	for i, rule := range rules.Rules {
+		for _, earlier := range rules.Rules[:i] {
+			// a rule that isn't forced is skipped for existing static files, so a forced rule after it
+			// can still match
+			if !earlier.Force && rule.Force {
+				continue
+			}
+			if redirectPattern(earlier.From) == redirectPattern(rule.From) {
+				return fmt.Errorf("%s:%d: rule for %s is a duplicate of the rule on line %d", name, rule.line, rule.From, earlier.line)
+			}
+			if redirectCovers(earlier.From, rule.From) {
+				return fmt.Errorf("%s:%d: rule for %s is shadowed by the rule for %s on line %d", name, rule.line, rule.From, earlier.From, earlier.line)
+			}
+		}
+	}
+	for _, seed := range rules.loopSeeds() {
+		rule, chain := rules.follow(seed)
+		if chain != nil {
+			return fmt.Errorf("%s:%d: rule for %s redirects in a loop: %s", name, rule.line, rule.From, strings.Join(chain, " -> "))
+		}
+	}
+	return nil
+}
+
+// loopSeeds returns the request paths redirects are followed from to look for loops: a sample
+// path of every rule, the target of that path, and the path of every rule as written, so
+// placeholders are also tried with the literal segments and placeholders of other rules.
+func (rules *redirectRules) loopSeeds() []string {
+	seeds := make([]string, 0, 3*len(rules.Rules))
+	seen := make(map[string]bool)
+	add := func(seed string) {
+		if !seen[seed] {
+			seen[seed] = true
+			seeds = append(seeds, seed)
+		}
+	}
+	for _, rule := range rules.Rules {
+		sample := redirectSamplePath(rule.From)
+		add(sample)
+		if params, ok := matchRedirectPath(rule.From, sample); ok && strings.HasPrefix(rule.To, "/") {
+			add(redirectPathOf(expandRedirectTarget(rule.To, params)))
+		}
+		add(rule.From)
+	}
+	return seeds
+}
+
+func validateRedirectRule(rule redirectRule) error {
+	if !strings.HasPrefix(rule.From, "/") {
+		return fmt.Errorf("path (%s) must start with /", rule.From)
+	}
+	if strings.Contains(rule.From, "?") {
+		return fmt.Errorf("path (%s) can't contain a query string", rule.From)
+	}
+	if !strings.HasPrefix(rule.To, "/") && !strings.HasPrefix(rule.To, "http://") && !strings.HasPrefix(rule.To, "https://") {
+		return fmt.Errorf("target (%s) must start with /, http:// or https://", rule.To)
+	}
+	switch rule.Status {
+	case http.StatusOK, http.StatusNotFound:
+		if !strings.HasPrefix(rule.To, "/") {
+			return fmt.Errorf("target (%s) of a %d rule must be a static file", rule.To, rule.Status)
+		}
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+	default:
+		return fmt.Errorf("status %d is not supported, use 200, 301, 302, 303, 307, 308 or 404", rule.Status)
+	}
+	placeholders := map[string]bool{}
+	segments := redirectSegments(rule.From)
+	for i, segment := range segments {
+		switch {
+		case segment == "*":
+			if i != len(segments)-1 {
+				return fmt.Errorf("path (%s) can only contain * at the end", rule.From)
+			}
+			placeholders["splat"] = true
+		case strings.Contains(segment, "*"):
+			return fmt.Errorf("path (%s) can only contain * as a whole segment", rule.From)
+		case strings.HasPrefix(segment, ":"):
+			if placeholders[segment[1:]] {
+				return fmt.Errorf("path (%s) contains placeholder %s twice", rule.From, segment)
+			}
+			placeholders[segment[1:]] = true
+		}
+	}
+	for _, m := range redirectPlaceholderPattern.FindAllStringSubmatch(rule.To, -1) {
+		if !placeholders[m[1]] {
+			return fmt.Errorf("target (%s) uses :%s, which is not in the path (%s)", rule.To, m[1], rule.From)
+		}
+	}
+	return nil
+}
+
+// follow follows the redirects of a request path and, when they redirect in a loop, returns the
+// first rule that redirected and the chain of paths, or a nil chain.
+func (rules *redirectRules) follow(requestPath string) (redirectRule, []string) {
+	var first *redirectRule
+	chain := []string{requestPath}
+	visited := map[string]bool{requestPath: true}
+	for {
+		next, ok := rules.normalizeTrailingSlash(requestPath, false)
+		if !ok {
+			rule, params, matched := rules.match(requestPath, func(string) bool { return false })
+			if !matched || !rule.isRedirect() || !strings.HasPrefix(rule.To, "/") {
+				return redirectRule{}, nil
+			}
+			if first == nil {
+				first = &rule
+			}
+			next = redirectPathOf(expandRedirectTarget(rule.To, params))
+		}
+		chain = append(chain, next)
+		if visited[next] {
+			if first == nil {
+				// trailing-slash redirects alone never loop
+				return redirectRule{}, nil
+			}
+			return *first, chain
+		}
+		visited[next] = true
+		requestPath = next
+	}
+}
+
+// redirectPathOf returns the path of a redirect target without its query string.
+func redirectPathOf(target string) string {
+	return strings.SplitN(target, "?", 2)[0]
+}
+
+// normalizeTrailingSlash returns the path to redirect to when the path doesn't match the
+// trailing-slash setting. Paths of existing static files and paths with an extension keep their
+// trailing slash as is.
+func (rules *redirectRules) normalizeTrailingSlash(requestPath string, exists bool) (string, bool) {
+	if rules == nil || exists || requestPath == "/" {
+		return "", false
+	}
+	switch rules.TrailingSlash {
+	case "add":
+		if !strings.HasSuffix(requestPath, "/") && path.Ext(requestPath) == "" {
+			return requestPath + "/", true
+		}
+	case "remove":
+		if strings.HasSuffix(requestPath, "/") {
+			return strings.TrimRight(requestPath, "/"), true
+		}
+	}
+	return "", false
+}
+
+// match returns the first rule that applies to the request path and the values of its
+// placeholders. Rules that aren't forced are skipped when a static file exists for the path.
+func (rules *redirectRules) match(requestPath string, exists func(string) bool) (redirectRule, map[string]string, bool) {
+	if rules == nil {
+		return redirectRule{}, nil, false
+	}
+	for _, rule := range rules.Rules {
+		if !rule.Force && exists(requestPath) {
+			continue
+		}
+		params, ok := matchRedirectPath(rule.From, requestPath)
+		if ok {
+			return rule, params, true
+		}
+	}
+	return redirectRule{}, nil, false
+}
+
+// matchRedirectPath matches a request path against the path of a rule. A trailing slash is
+// ignored.
+func matchRedirectPath(pattern, requestPath string) (map[string]string, bool) {
+	patternSegments := redirectSegments(pattern)
+	pathSegments := redirectSegments(requestPath)
+	params := map[string]string{}
+	for i, segment := range patternSegments {
+		if segment == "*" {
+			params["splat"] = strings.Join(pathSegments[i:], "/")
+			return params, true
+		}
+		if i >= len(pathSegments) {
+			return nil, false
+		}
+		if strings.HasPrefix(segment, ":") {
+			params[segment[1:]] = pathSegments[i]
+			continue
+		}
+		if segment != pathSegments[i] {
+			return nil, false
+		}
+	}
+	return params, len(patternSegments) == len(pathSegments)
+}
+
+func expandRedirectTarget(target string, params map[string]string) string {
+	return redirectPlaceholderPattern.ReplaceAllStringFunc(target, func(placeholder string) string {
+		if value, ok := params[placeholder[1:]]; ok {
+			return value
+		}
+		return placeholder
+	})
+}
+
+func redirectSegments(p string) []string {
+	p = strings.Trim(p, "/")
+	if p == "" {
+		return []string{}
+	}
+	return strings.Split(p, "/")
+}
+
+// redirectPattern normalizes a rule path so paths that only differ in placeholder names are equal.
+func redirectPattern(p string) string {
+	return routePattern(strings.TrimSuffix(p, "/"))
+}
+
+// redirectCovers reports whether every path matched by later is also matched by earlier.
+func redirectCovers(earlier, later string) bool {
+	earlierSegments := redirectSegments(earlier)
+	laterSegments := redirectSegments(later)
+	for i, segment := range earlierSegments {
+		if segment == "*" {
+			return true
+		}
+		if i >= len(laterSegments) || laterSegments[i] == "*" {
+			return false
+		}
+		if !strings.HasPrefix(segment, ":") && segment != laterSegments[i] {
+			return false
+		}
+	}
+	return len(earlierSegments) == len(laterSegments)
+}
+
+// redirectSamplePath returns a request path matched by a rule path, used to look for loops.
+func redirectSamplePath(p string) string {
+	segments := redirectSegments(p)
+	for i, segment := range segments {
+		switch {
+		case segment == "*":
+			segments[i] = "splat"
+		case strings.HasPrefix(segment, ":"):
+			segments[i] = segment[1:]
+		}
+	}
+	return "/" + strings.Join(segments, "/")
+}
+
+// redirectsManifest returns the rules as the _redirects.json static file, or nil without rules.
+func redirectsManifest() ([]byte, error) {
+	rules, err := loadRedirectRules()
+	if err != nil || rules == nil {
+		return nil, err
+	}
+	return json.MarshalIndent(rules, "", "  ")
+}
+
+// deployRedirects uploads the redirect rules as _redirects.json next to the static files.
+func deployRedirects(deployKey string) error {
+	manifest, err := redirectsManifest()
+	if err != nil || manifest == nil {
+		return err
+	}
+	p("statics", "deploying file %s (application/json)", redirectsManifestName)
+	return deployFile(deployKey, "static", redirectsManifestName, "application/json", bytesBody(manifest))
+}
+
+// addArtifactRedirects adds the redirect rules to the artifact as _redirects.json.
+func addArtifactRedirects(w *artifactWriter) error {
+	manifest, err := redirectsManifest()
+	if err != nil || manifest == nil {
+		return err
+	}
+	p("statics", "adding file %s (application/json)\n", redirectsManifestName)
+	return w.add("static", redirectsManifestName, "application/json", manifest)
+}
+
+func addRedirectsFlags(flags *pflag.FlagSet) {
+	flags.StringVarP(&redirectsFile, "redirects-file", "", "", fmt.Sprintf("the file with the redirect and rewrite rules for static files [%s]", cavemarkRedirectsFile))
+	redirectsFile = resolveStringFlag(redirectsFile, cavemarkRedirectsFile, defaultRedirectsFile)
+}
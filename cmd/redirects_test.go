@@ -0,0 +1,195 @@
+package cmd
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseRedirectRules(t *testing.T) {
+	tests := []struct {
+		name    string
+		src     string
+		want    *redirectRules
+		wantErr string
+	}{
+		{
+			name: "rules and comments",
+			src:  "# moved\n/old /new\n\n/blog/:slug /posts/:slug 302\n/app/* /index.html 200!\ntrailing-slash remove\n",
+			want: &redirectRules{TrailingSlash: "remove", Rules: []redirectRule{
+				{From: "/old", To: "/new", Status: 301, line: 2},
+				{From: "/blog/:slug", To: "/posts/:slug", Status: 302, line: 4},
+				{From: "/app/*", To: "/index.html", Status: 200, Force: true, line: 5},
+			}},
+		},
+		{
+			name:    "missing target",
+			src:     "/old\n",
+			wantErr: "_redirects:1: rule must be in the form from to [status]",
+		},
+		{
+			name:    "status not a number",
+			src:     "/old /new moved\n",
+			wantErr: "_redirects:1: status (moved) is not a number",
+		},
+		{
+			name:    "invalid trailing-slash",
+			src:     "trailing-slash keep\n",
+			wantErr: "_redirects:1: trailing-slash must be followed by add or remove",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseRedirectRules("_redirects", strings.NewReader(tt.src))
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("parseRedirectRules() error = %v, want %s", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseRedirectRules() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateRedirectRules(t *testing.T) {
+	tests := []struct {
+		name    string
+		src     string
+		wantErr string
+	}{
+		{
+			name: "valid",
+			src:  "/old /new\n/blog/:slug /posts/:slug\n/docs/* https://docs.example.com/:splat 302\n",
+		},
+		{
+			name:    "relative path",
+			src:     "old /new\n",
+			wantErr: "_redirects:1: path (old) must start with /",
+		},
+		{
+			name:    "external rewrite",
+			src:     "/api/* https://api.example.com/:splat 200\n",
+			wantErr: "_redirects:1: target (https://api.example.com/:splat) of a 200 rule must be a static file",
+		},
+		{
+			name:    "unsupported status",
+			src:     "/old /new 418\n",
+			wantErr: "_redirects:1: status 418 is not supported, use 200, 301, 302, 303, 307, 308 or 404",
+		},
+		{
+			name:    "splat in the middle",
+			src:     "/a/*/b /c\n",
+			wantErr: "_redirects:1: path (/a/*/b) can only contain * at the end",
+		},
+		{
+			name:    "unknown placeholder",
+			src:     "/users/:id /people/:name\n",
+			wantErr: "_redirects:1: target (/people/:name) uses :name, which is not in the path (/users/:id)",
+		},
+		{
+			name:    "duplicate",
+			src:     "/users/:id /a\n/users/:name /b\n",
+			wantErr: "_redirects:2: rule for /users/:name is a duplicate of the rule on line 1",
+		},
+		{
+			name:    "shadowed by a placeholder",
+			src:     "/users/:id /a\n/users/me /b\n",
+			wantErr: "_redirects:2: rule for /users/me is shadowed by the rule for /users/:id on line 1",
+		},
+		{
+			name:    "shadowed by a splat",
+			src:     "/docs/* /a\n/docs/v1/intro /b\n",
+			wantErr: "_redirects:2: rule for /docs/v1/intro is shadowed by the rule for /docs/* on line 1",
+		},
+		{
+			name: "forced rule after a rule that isn't forced",
+			src:  "/app/* /index.html 200\n/app/config.json /config.json 200!\n",
+		},
+		{
+			name:    "direct loop",
+			src:     "/a /b\n/b /a\n",
+			wantErr: "_redirects:1: rule for /a redirects in a loop: /a -> /b -> /a",
+		},
+		{
+			name:    "loop through a placeholder",
+			src:     "/a/:x /b/:x\n/b/foo /a/foo\n",
+			wantErr: "_redirects:2: rule for /b/foo redirects in a loop: /b/foo -> /a/foo -> /b/foo",
+		},
+		{
+			name:    "loop through a splat",
+			src:     "/old/* /new/:splat\n/new/* /old/:splat\n",
+			wantErr: "_redirects:1: rule for /old/* redirects in a loop: /old/splat -> /new/splat -> /old/splat",
+		},
+		{
+			name:    "loop through the trailing slash",
+			src:     "trailing-slash add\n/a/ /a 301\n",
+			wantErr: "_redirects:2: rule for /a/ redirects in a loop: /a -> /a/ -> /a",
+		},
+		{
+			name: "rewrites don't loop",
+			src:  "/a /b 200\n/b /a 200\n",
+		},
+		{
+			name: "chain that ends",
+			src:  "/a /b\n/b /c\n/c/:x /a\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rules, err := parseRedirectRules("_redirects", strings.NewReader(tt.src))
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = rules.validate("_redirects")
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("validate() error = %v", err)
+				}
+				return
+			}
+			if err == nil || err.Error() != tt.wantErr {
+				t.Errorf("validate() error = %v, want %s", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestMatchRedirectRules(t *testing.T) {
+	rules, err := parseRedirectRules("_redirects", strings.NewReader("/blog/:year/:slug /posts/:slug?year=:year\n/docs/* /manual/:splat 302\n/app/* /index.html 200\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		path   string
+		exists bool
+		want   string
+		status int
+	}{
+		{"/blog/2021/hello", false, "/posts/hello?year=2021", 301},
+		{"/blog/2021/hello/", false, "/posts/hello?year=2021", 301},
+		{"/docs/a/b", false, "/manual/a/b", 302},
+		{"/docs", false, "/manual/", 302},
+		{"/app/settings", false, "/index.html", 200},
+		// rules that aren't forced don't apply to existing files
+		{"/app/logo.png", true, "", 0},
+		{"/blog/2021", false, "", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			rule, params, ok := rules.match(tt.path, func(string) bool { return tt.exists })
+			got, status := "", 0
+			if ok {
+				got, status = expandRedirectTarget(rule.To, params), rule.Status
+			}
+			if got != tt.want || status != tt.status {
+				t.Errorf("match(%s) = %s %d, want %s %d", tt.path, got, status, tt.want, tt.status)
+			}
+		})
+	}
+}
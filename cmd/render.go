@@ -0,0 +1,193 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/cbroglie/mustache"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+var (
+	renderScope        string
+	validatedTemplates []string
+)
+
+const (
+	cavemarkValidateTemplate = "CAVEMARK_VALIDATE_TEMPLATE"
+)
+
+var templateExtensions = []string{".mustache", ".html", ".htm"}
+
+var renderCmd = &cobra.Command{
+	Use:   "render <template>",
+	Short: "render a mustache template",
+	Long: `Renders a mustache template from the resource directory.
+
+Templates are found the same way namespace.v1.mustache finds them: the name doesn't need an
+extension and partials ({{> name}}) are found in the resource directory too.
+
+Example:
+  # renders resource/account.mustache with the values in data.json
+  cavemark render account --scope data.json`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var scope interface{}
+		if renderScope != "" {
+			data, err := ioutil.ReadFile(renderScope)
+			if err != nil {
+				return err
+			}
+			err = json.Unmarshal(data, &scope)
+			if err != nil {
+				return fmt.Errorf("error reading scope (%s): %w", renderScope, err)
+			}
+		}
+		out, err := renderTemplate(resourceDir, args[0], scope)
+		if err != nil {
+			return err
+		}
+		fmt.Print(out)
+		return nil
+	},
+}
+
+// findTemplate returns the file in dir for a template name. Like on the server, the name doesn't
+// need an extension: "emails/welcome" finds emails/welcome.mustache or emails/welcome.html.
+func findTemplate(dir, name string) (string, error) {
+	name = filepath.FromSlash(strings.TrimPrefix(name, "/"))
+	exact := filepath.Join(dir, name)
+	if info, err := os.Stat(exact); err == nil && !info.IsDir() {
+		return exact, nil
+	}
+	matches, err := filepath.Glob(exact + ".*")
+	if err != nil {
+		return "", err
+	}
+	sort.Strings(matches)
+	for _, match := range matches {
+		if info, err := os.Stat(match); err == nil && !info.IsDir() {
+			return match, nil
+		}
+	}
+	return "", fmt.Errorf("template (%s) not found in '%s'", name, dir)
+}
+
+// resourcePartials finds partials in the resource directory.
+type resourcePartials struct {
+	dir string
+}
+
+func (r resourcePartials) Get(name string) (string, error) {
+	f, err := findTemplate(r.dir, name)
+	if err != nil {
+		return "", err
+	}
+	data, err := ioutil.ReadFile(f)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+func parseTemplate(dir, name string) (*mustache.Template, error) {
+	f, err := findTemplate(dir, name)
+	if err != nil {
+		return nil, err
+	}
+	tmpl, err := mustache.ParseFilePartials(f, resourcePartials{dir})
+	if err != nil {
+		return nil, fmt.Errorf("error parsing template (%s): %w", f, err)
+	}
+	return tmpl, nil
+}
+
+func renderTemplate(dir, name string, scope interface{}) (string, error) {
+	tmpl, err := parseTemplate(dir, name)
+	if err != nil {
+		return "", err
+	}
+	return tmpl.Render(scope)
+}
+
+// validateTemplates parses the .mustache files in dir, and the files that match a
+// --validate-template pattern, so syntax errors are found before the templates are deployed.
+// Other HTML resources aren't validated, since they may contain {{ for other reasons, such as
+// the markup of a client-side framework.
+func validateTemplates(dir, defaultDir string) error {
+	if dir == "" {
+		return nil
+	}
+	_, err := os.Lstat(dir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) && dir == defaultDir {
+			return nil
+		}
+		return err
+	}
+	files, err := globAll(dir)
+	if err != nil {
+		return fmt.Errorf("error globbing files: %w", err)
+	}
+	for _, f := range files {
+		validated, err := isValidatedTemplate(filepath.ToSlash(removeDir(f, dir)))
+		if err != nil {
+			return err
+		}
+		if !validated {
+			continue
+		}
+		_, err = mustache.ParseFilePartials(f, resourcePartials{dir})
+		if err != nil {
+			return fmt.Errorf("error parsing template (%s): %w", f, err)
+		}
+	}
+	return nil
+}
+
+// isValidatedTemplate reports whether a resource file, relative to the resource directory, is
+// validated as a template.
+func isValidatedTemplate(filePath string) (bool, error) {
+	if strings.ToLower(path.Ext(filePath)) == ".mustache" {
+		return true, nil
+	}
+	for _, pattern := range validatedTemplates {
+		matched, err := path.Match(strings.TrimPrefix(pattern, "/"), filePath)
+		if err != nil {
+			return false, fmt.Errorf("template pattern (%s) is invalid: %w", pattern, err)
+		}
+		if matched {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func isTemplate(f string) bool {
+	ext := strings.ToLower(filepath.Ext(f))
+	for _, e := range templateExtensions {
+		if ext == e {
+			return true
+		}
+	}
+	return false
+}
+
+func addValidateTemplateFlag(flags *pflag.FlagSet) {
+	flags.StringArrayVarP(&validatedTemplates, "validate-template", "", nil, fmt.Sprintf("validate the resource files that match this pattern as mustache templates besides .mustache files, e.g. emails/*.html [%s]", cavemarkValidateTemplate))
+	validatedTemplates = resolveStringSliceFlag(validatedTemplates, cavemarkValidateTemplate, nil)
+}
+
+func init() {
+	renderCmd.Flags().StringVarP(&renderScope, "scope", "", "", "a JSON file with the values used in the template")
+	addResourceDirFlag(renderCmd.Flags())
+	rootCmd.AddCommand(renderCmd)
+}
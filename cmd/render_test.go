@@ -0,0 +1,34 @@
+package cmd
+
+import "testing"
+
+func TestIsValidatedTemplate(t *testing.T) {
+	defer func(patterns []string) { validatedTemplates = patterns }(validatedTemplates)
+	validatedTemplates = []string{"emails/*.html", "/pages/*.htm"}
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{"account.mustache", true},
+		{"emails/welcome.MUSTACHE", true},
+		{"app.html", false},
+		{"emails/welcome.html", true},
+		{"emails/nested/welcome.html", false},
+		{"pages/index.htm", true},
+		{"data.json", false},
+	}
+	for _, tt := range tests {
+		got, err := isValidatedTemplate(tt.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("isValidatedTemplate(%q) = %v, want %v", tt.path, got, tt.want)
+		}
+	}
+
+	validatedTemplates = []string{"emails/[.html"}
+	if _, err := isValidatedTemplate("emails/welcome.html"); err == nil {
+		t.Error("isValidatedTemplate() with an invalid pattern should fail")
+	}
+}
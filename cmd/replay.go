@@ -0,0 +1,347 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	neturl "net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var replayCmd = &cobra.Command{
+	Use:   "replay <file.har>",
+	Short: "replay recorded requests against the local functions",
+	Long: `Replays requests recorded with "cavemark dev --record" against the locally bundled function
+and compares every response with the recorded one.
+
+The status, content type and body must match. JSON bodies are compared by value, so the order of
+keys doesn't matter.
+
+Requests run against a new temporary database and mailbox, so the responses don't depend on the
+state left by "cavemark dev" or by an earlier replay, and a replay doesn't change that state.
+Record requests starting from an empty database, e.g. with "cavemark dev --db" set to a new
+file, so the recording replays the same way. Pass the same --content-type, --headers-file and
+--redirects-file as the "cavemark dev" session, so static files get the same responses.
+
+Examples:
+  # records requests while using the app
+  cavemark dev --db .cavemark/record.sqlite --record requests.har
+
+  # checks that the function still responds the same way
+  cavemark replay requests.har`,
+	Args:         cobra.ExactArgs(1),
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		p("cavemark", "version %s\n", cmd.Parent().Version)
+		har, err := readHAR(args[0])
+		if err != nil {
+			return err
+		}
+		result, err := bundle()
+		if err != nil {
+			return err
+		}
+		rt, remove, err := newTempRuntime()
+		if err != nil {
+			return err
+		}
+		defer remove()
+		err = rt.load(result.code)
+		if err != nil {
+			return err
+		}
+		err = rt.loadStaticRules()
+		if err != nil {
+			return err
+		}
+
+		failed := 0
+		for _, entry := range har.Log.Entries {
+			req, err := entry.Request.runtimeRequest()
+			if err != nil {
+				return err
+			}
+			res, err := rt.run(req)
+			if err != nil {
+				failed++
+				p("diff", "%s %s\n", req.method, req.path)
+				p("", "            %s\n", err)
+				continue
+			}
+			diffs := entry.Response.diff(res)
+			if len(diffs) == 0 {
+				p("match", "%s %s [%d]\n", req.method, req.path, res.status)
+				continue
+			}
+			failed++
+			p("diff", "%s %s\n", req.method, req.path)
+			for _, d := range diffs {
+				p("", "%s\n", indent(d, "            "))
+			}
+		}
+		p("replay", "%d of %d responses match\n", len(har.Log.Entries)-failed, len(har.Log.Entries))
+		if failed > 0 {
+			return errors.New("responses differ from the recording")
+		}
+		return nil
+	},
+}
+
+// har is the subset of the HTTP Archive format (HAR 1.2) needed to replay requests to a function.
+type har struct {
+	Log harLog `json:"log"`
+}
+
+type harLog struct {
+	Version string     `json:"version"`
+	Creator harCreator `json:"creator"`
+	Entries []harEntry `json:"entries"`
+}
+
+type harCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type harEntry struct {
+	StartedDateTime time.Time   `json:"startedDateTime"`
+	Time            float64     `json:"time"`
+	Request         harRequest  `json:"request"`
+	Response        harResponse `json:"response"`
+}
+
+type harRequest struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HTTPVersion string         `json:"httpVersion"`
+	Headers     []harNameValue `json:"headers"`
+	QueryString []harNameValue `json:"queryString"`
+	PostData    *harPostData   `json:"postData,omitempty"`
+}
+
+type harPostData struct {
+	MimeType string         `json:"mimeType"`
+	Text     string         `json:"text"`
+	Params   []harNameValue `json:"params,omitempty"`
+}
+
+type harResponse struct {
+	Status      int            `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HTTPVersion string         `json:"httpVersion"`
+	Headers     []harNameValue `json:"headers"`
+	Content     harContent     `json:"content"`
+}
+
+type harContent struct {
+	Size     int    `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+}
+
+type harNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+func readHAR(file string) (*har, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	h := &har{}
+	err = json.Unmarshal(data, h)
+	if err != nil {
+		return nil, fmt.Errorf("error reading HAR file (%s): %w", file, err)
+	}
+	return h, nil
+}
+
+// harRecorder writes requests served by "cavemark dev" to a HAR file. The whole file is written
+// after every request, so the recording is complete whenever dev is stopped.
+type harRecorder struct {
+	mu   sync.Mutex
+	file string
+	har  har
+}
+
+// newHARRecorder returns a recorder that writes to file. An existing file is only overwritten
+// when force is set.
+func newHARRecorder(file, version string, force bool) (*harRecorder, error) {
+	_, err := os.Stat(file)
+	if err == nil && !force {
+		return nil, fmt.Errorf("HAR file (%s) already exists, use --force to overwrite it", file)
+	}
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	err = os.MkdirAll(filepath.Dir(file), 0755)
+	if err != nil {
+		return nil, err
+	}
+	return &harRecorder{
+		file: file,
+		har: har{Log: harLog{
+			Version: "1.2",
+			Creator: harCreator{Name: "cavemark", Version: version},
+			Entries: make([]harEntry, 0),
+		}},
+	}, nil
+}
+
+func (r *harRecorder) record(req *runtimeRequest, res *runtimeResponse, contentType string, start time.Time) error {
+	entry := harEntry{
+		StartedDateTime: start,
+		Time:            float64(time.Since(start).Microseconds()) / 1000,
+		Request: harRequest{
+			Method:      req.method,
+			URL:         req.fullURL,
+			HTTPVersion: "HTTP/1.1",
+			Headers:     make([]harNameValue, 0),
+			QueryString: harNameValues(req.query),
+		},
+		Response: harResponse{
+			Status:      res.status,
+			StatusText:  http.StatusText(res.status),
+			HTTPVersion: "HTTP/1.1",
+			Headers:     make([]harNameValue, 0),
+			Content: harContent{
+				Size:     len(res.body),
+				MimeType: res.headers.Get("Content-Type"),
+				Text:     string(res.body),
+			},
+		},
+	}
+	if req.body != "" || len(req.form) > 0 {
+		entry.Request.PostData = &harPostData{MimeType: contentType, Text: req.body, Params: harNameValues(req.form)}
+	}
+	for key, values := range res.headers {
+		for _, value := range values {
+			entry.Response.Headers = append(entry.Response.Headers, harNameValue{Name: key, Value: value})
+		}
+	}
+	sort.Slice(entry.Response.Headers, func(i, j int) bool {
+		return entry.Response.Headers[i].Name < entry.Response.Headers[j].Name
+	})
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.har.Log.Entries = append(r.har.Log.Entries, entry)
+	data, err := json.MarshalIndent(r.har, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(r.file, data, 0644)
+}
+
+func harNameValues(values map[string][]string) []harNameValue {
+	result := make([]harNameValue, 0)
+	for key, vs := range values {
+		for _, v := range vs {
+			result = append(result, harNameValue{Name: key, Value: v})
+		}
+	}
+	sort.SliceStable(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result
+}
+
+// runtimeRequest converts a recorded request into a request for the runtime.
+func (r harRequest) runtimeRequest() (*runtimeRequest, error) {
+	u, err := neturl.Parse(r.URL)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing recorded url (%s): %w", r.URL, err)
+	}
+	req := &runtimeRequest{
+		method:  r.Method,
+		path:    u.Path,
+		fullURL: r.URL,
+		form:    make(map[string][]string),
+		query:   u.Query(),
+	}
+	if r.PostData != nil {
+		req.body = r.PostData.Text
+		for _, param := range r.PostData.Params {
+			req.form[param.Name] = append(req.form[param.Name], param.Value)
+		}
+	}
+	return req, nil
+}
+
+// diff describes how res differs from the recorded response.
+func (r harResponse) diff(res *runtimeResponse) []string {
+	diffs := make([]string, 0)
+	if r.Status != res.status {
+		diffs = append(diffs, fmt.Sprintf("status: expected %d, got %d", r.Status, res.status))
+	}
+	expectedType := mediaType(r.Content.MimeType)
+	actualType := mediaType(res.headers.Get("Content-Type"))
+	if expectedType != actualType {
+		diffs = append(diffs, fmt.Sprintf("content type: expected %q, got %q", expectedType, actualType))
+	}
+	if !bodiesEqual(actualType, []byte(r.Content.Text), res.body) {
+		diffs = append(diffs, "body:\n"+textDiff(r.Content.Text, string(res.body)))
+	}
+	return diffs
+}
+
+func mediaType(contentType string) string {
+	if contentType == "" {
+		return ""
+	}
+	t, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return contentType
+	}
+	return t
+}
+
+func bodiesEqual(contentType string, expected, actual []byte) bool {
+	if bytes.Equal(expected, actual) {
+		return true
+	}
+	if contentType != "application/json" {
+		return false
+	}
+	var e, a interface{}
+	if json.Unmarshal(expected, &e) != nil || json.Unmarshal(actual, &a) != nil {
+		return false
+	}
+	return reflect.DeepEqual(e, a)
+}
+
+// textDiff shows the lines that differ between two bodies, prefixed with - and +.
+func textDiff(expected, actual string) string {
+	e := strings.Split(expected, "\n")
+	a := strings.Split(actual, "\n")
+	lines := make([]string, 0)
+	for i := 0; i < len(e) || i < len(a); i++ {
+		switch {
+		case i >= len(e):
+			lines = append(lines, "+ "+a[i])
+		case i >= len(a):
+			lines = append(lines, "- "+e[i])
+		case e[i] != a[i]:
+			lines = append(lines, "- "+e[i], "+ "+a[i])
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+func init() {
+	addProjectDirFlags(replayCmd.Flags())
+	addBundleFlags(replayCmd.Flags())
+	addStaticRuleFlags(replayCmd.Flags())
+	rootCmd.AddCommand(replayCmd)
+}
@@ -0,0 +1,133 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+var routesCmd = &cobra.Command{
+	Use:   "routes",
+	Short: "list the routes of the function",
+	Long: `Lists the routes the function registers with namespace.v1.router.
+
+The function's main runs once with a GET request for "/" and a router that records routes
+instead of running them, so routes that are only registered for some requests aren't listed.
+While routes are recorded, database statements return no rows and change nothing, and mail
+isn't sent, so listing routes doesn't touch the local database and mailbox.
+
+Routes are matched in the order they are registered and the first match wins. The following
+routes can never be matched and are reported:
+  - duplicates of an earlier route
+  - routes shadowed by an earlier route, e.g. /users/me after /users/:id
+  - routes registered after router.route(namespace) is called
+
+Example:
+  cavemark routes`,
+	Args:         cobra.MaximumNArgs(0),
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		result, err := bundle()
+		if err != nil {
+			return err
+		}
+		// recording routes doesn't use the database and mailbox
+		rt := newDevRuntime("", "")
+		defer rt.close()
+		err = rt.load(result.code)
+		if err != nil {
+			return err
+		}
+		router, err := rt.recordRoutes()
+		if err != nil {
+			return err
+		}
+		problems := printRoutes(router)
+		if problems > 0 {
+			return fmt.Errorf("found %d unreachable routes", problems)
+		}
+		return nil
+	},
+}
+
+// printRoutes prints the route table and returns the number of unreachable routes.
+func printRoutes(router *runtimeRouter) int {
+	fmt.Println("Method \tPath                          \tMiddleware\tProblem")
+	fmt.Println("-------\t------------------------------\t----------\t-------")
+	problems := 0
+	for i, route := range router.routes {
+		middleware := "no"
+		if route.middleware != nil {
+			middleware = "yes"
+		}
+		problem := routeProblem(router, i)
+		if problem != "" {
+			problems++
+		}
+		fmt.Printf("%-7s\t%-30s\t%-10s\t%s\n", route.method, route.path, middleware, problem)
+	}
+	if router.useStatic {
+		fmt.Printf("%-7s\t%-30s\t%-10s\t%s\n", "GET", "(static files)", "no", "")
+	}
+	if len(router.routes) > 0 && router.routedAt < 0 {
+		p("warning", "router.route(namespace) is never called, no route can be matched\n")
+	}
+	return problems
+}
+
+// routeProblem explains why route i can never be matched, or returns "".
+func routeProblem(router *runtimeRouter, i int) string {
+	route := router.routes[i]
+	if router.routedAt >= 0 && i >= router.routedAt {
+		return "registered after router.route(namespace)"
+	}
+	for j := 0; j < i; j++ {
+		earlier := router.routes[j]
+		if earlier.method != route.method {
+			continue
+		}
+		if routePattern(earlier.path) == routePattern(route.path) {
+			return fmt.Sprintf("duplicate of %s %s", earlier.method, earlier.path)
+		}
+		if routeCovers(earlier.path, route.path) {
+			return fmt.Sprintf("shadowed by %s %s", earlier.method, earlier.path)
+		}
+	}
+	return ""
+}
+
+// routePattern normalizes a route path so paths that only differ in parameter names are equal.
+func routePattern(routePath string) string {
+	segments := strings.Split(strings.Trim(routePath, "/"), "/")
+	for i, segment := range segments {
+		if strings.HasPrefix(segment, ":") {
+			segments[i] = ":"
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// routeCovers reports whether every request path matched by later is also matched by earlier.
+func routeCovers(earlier, later string) bool {
+	earlierSegments := strings.Split(strings.Trim(earlier, "/"), "/")
+	laterSegments := strings.Split(strings.Trim(later, "/"), "/")
+	if len(earlierSegments) != len(laterSegments) {
+		return false
+	}
+	for i, segment := range earlierSegments {
+		if strings.HasPrefix(segment, ":") {
+			continue
+		}
+		if segment != laterSegments[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func init() {
+	addProjectDirFlags(routesCmd.Flags())
+	addBundleFlags(routesCmd.Flags())
+	rootCmd.AddCommand(routesCmd)
+}
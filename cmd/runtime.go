@@ -0,0 +1,436 @@
+package cmd
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/dop251/goja"
+)
+
+// devRuntime emulates the Cavemark runtime locally. Every request runs the function bundle in a
+// new JavaScript VM, the same way every request is isolated on the server.
+type devRuntime struct {
+	mu      sync.RWMutex
+	program *goja.Program
+
+	dbPath string
+	dbs    map[string]*sql.DB
+	dbMu   sync.Mutex
+	// sqliteDir holds the files of "sqlite:" connection strings instead of their own paths,
+	// unless empty
+	sqliteDir string
+
+	mailDir string
+
+	// redirects and headerRules are the rules of static files, rulesErr is set while they are
+	// invalid
+	redirects   *redirectRules
+	headerRules headerRules
+	rulesErr    error
+	// staticIgnore leaves out the static files that .cavemarkignore files keep out of deployments
+	staticIgnore *ignoreMatcher
+
+	recorder   *harRecorder
+	liveReload *liveReload
+}
+
+func newDevRuntime(dbPath, mailDir string) *devRuntime {
+	return &devRuntime{dbPath: dbPath, dbs: make(map[string]*sql.DB), mailDir: mailDir}
+}
+
+// newTempRuntime returns a runtime with a new database and mailbox in a temporary directory, so
+// it neither sees nor changes the state of "cavemark dev". Databases of "sqlite:" connection
+// strings are created in the temporary directory as well. remove closes the runtime and deletes
+// the directory.
+func newTempRuntime() (rt *devRuntime, remove func(), err error) {
+	tmp, err := ioutil.TempDir("", "cavemark-runtime")
+	if err != nil {
+		return nil, nil, err
+	}
+	rt = newDevRuntime(filepath.Join(tmp, "dev.sqlite"), filepath.Join(tmp, "mail"))
+	rt.sqliteDir = filepath.Join(tmp, "sqlite")
+	remove = func() {
+		rt.close()
+		_ = os.RemoveAll(tmp)
+	}
+	return rt, remove, nil
+}
+
+// load compiles a function bundle, replacing the bundle used by new requests.
+func (rt *devRuntime) load(code []byte) error {
+	program, err := goja.Compile("index.js", string(code), false)
+	if err != nil {
+		return fmt.Errorf("error compiling bundle: %w", err)
+	}
+	rt.mu.Lock()
+	rt.program = program
+	rt.mu.Unlock()
+	return nil
+}
+
+// loadStaticRules reads the redirect and header rules of static files and the ignore files of the
+// static directory, replacing the rules used by new requests. Requests for static files fail
+// while the rules or the content types are invalid.
+func (rt *devRuntime) loadStaticRules() error {
+	var ignore *ignoreMatcher
+	err := validateContentTypes()
+	if err == nil && staticDir != "" {
+		ignore, err = newIgnoreMatcher(staticDir)
+	}
+	var redirects *redirectRules
+	if err == nil {
+		redirects, err = loadRedirectRules()
+	}
+	var headers headerRules
+	if err == nil {
+		headers, err = loadHeaderRules()
+	}
+	rt.mu.Lock()
+	rt.redirects, rt.headerRules, rt.rulesErr, rt.staticIgnore = redirects, headers, err, ignore
+	rt.mu.Unlock()
+	return err
+}
+
+func (rt *devRuntime) close() {
+	rt.dbMu.Lock()
+	defer rt.dbMu.Unlock()
+	for _, db := range rt.dbs {
+		_ = db.Close()
+	}
+}
+
+type runtimeRequest struct {
+	method  string
+	path    string
+	fullURL string
+	body    string
+	form    map[string][]string
+	query   map[string][]string
+}
+
+type runtimeResponse struct {
+	status   int
+	headers  http.Header
+	body     []byte
+	finished bool
+}
+
+// runtimeCall holds the state of a single request while it runs in the VM.
+type runtimeCall struct {
+	rt     *devRuntime
+	vm     *goja.Runtime
+	req    *runtimeRequest
+	res    *runtimeResponse
+	router *runtimeRouter
+
+	// recordRoutes makes router.route record the routes instead of running them, and stubs the
+	// database and mail.
+	recordRoutes bool
+}
+
+// run calls the bundle's main function with a namespace for the request and returns the response.
+func (rt *devRuntime) run(req *runtimeRequest) (*runtimeResponse, error) {
+	call, err := rt.call(req, false)
+	if err != nil {
+		return nil, err
+	}
+	return call.res, nil
+}
+
+// recordRoutes calls the bundle's main function with a router that records routes instead of
+// running them, and returns the router. Database statements do nothing and mail isn't sent
+// while routes are recorded, so recording neither reads nor changes the database and mailbox.
+func (rt *devRuntime) recordRoutes() (*runtimeRouter, error) {
+	req := &runtimeRequest{
+		method:  http.MethodGet,
+		path:    "/",
+		fullURL: "http://localhost/",
+		form:    make(map[string][]string),
+		query:   make(map[string][]string),
+	}
+	call, err := rt.call(req, true)
+	if err != nil {
+		return nil, err
+	}
+	if call.router == nil {
+		return &runtimeRouter{recording: true, routedAt: -1}, nil
+	}
+	return call.router, nil
+}
+
+func (rt *devRuntime) call(req *runtimeRequest, recordRoutes bool) (*runtimeCall, error) {
+	rt.mu.RLock()
+	program := rt.program
+	rt.mu.RUnlock()
+	if program == nil {
+		return nil, errors.New("no function bundle loaded")
+	}
+
+	call := &runtimeCall{
+		rt:           rt,
+		vm:           goja.New(),
+		req:          req,
+		res:          &runtimeResponse{status: http.StatusOK, headers: make(http.Header)},
+		recordRoutes: recordRoutes,
+	}
+	_ = call.vm.Set("console", call.newConsole())
+	_, err := call.vm.RunProgram(program)
+	if err != nil {
+		return nil, err
+	}
+	main, ok := goja.AssertFunction(call.vm.Get("main"))
+	if !ok {
+		return nil, errors.New("the bundle doesn't define a main function")
+	}
+	_, err = main(goja.Undefined(), call.newNamespace())
+	if err != nil {
+		return nil, err
+	}
+	return call, nil
+}
+
+func (c *runtimeCall) newNamespace() *goja.Object {
+	v1 := c.vm.NewObject()
+	_ = v1.Set("response", c.newResponse())
+	_ = v1.Set("request", c.newRequest())
+	_ = v1.Set("router", c.newRouter())
+	_ = v1.Set("db", c.db)
+	_ = v1.Set("mail", c.newMail())
+	_ = v1.Set("mustache", c.newMustache())
+	_ = v1.Set("validate", c.newValidate())
+	_ = v1.Set("crypto", c.newCrypto())
+	_ = v1.Set("money", c.newMoney())
+	namespace := c.vm.NewObject()
+	_ = namespace.Set("v1", v1)
+	return namespace
+}
+
+func (c *runtimeCall) newConsole() *goja.Object {
+	console := c.vm.NewObject()
+	log := func(call goja.FunctionCall) goja.Value {
+		args := make([]string, 0, len(call.Arguments))
+		for _, arg := range call.Arguments {
+			args = append(args, arg.String())
+		}
+		p("console", "%s\n", strings.Join(args, " "))
+		return goja.Undefined()
+	}
+	for _, name := range []string{"log", "info", "warn", "error", "debug"} {
+		_ = console.Set(name, log)
+	}
+	return console
+}
+
+func (c *runtimeCall) newRequest() *goja.Object {
+	request := c.vm.NewObject()
+	_ = request.Set("method", c.req.method)
+	_ = request.Set("path", c.req.path)
+	_ = request.Set("fullURL", c.req.fullURL)
+	_ = request.Set("body", c.req.body)
+
+	form := c.vm.NewObject()
+	_ = form.Set("get", func(name string) goja.Value {
+		if values := c.req.form[name]; len(values) > 0 {
+			return c.vm.ToValue(values[0])
+		}
+		return goja.Null()
+	})
+	_ = form.Set("getAll", func(name string) []string {
+		return append(make([]string, 0), c.req.form[name]...)
+	})
+	_ = request.Set("form", form)
+
+	_ = request.Set("queryValue", func(name string, defaultValue goja.Value) goja.Value {
+		if values := c.req.query[name]; len(values) > 0 {
+			return c.vm.ToValue(values[0])
+		}
+		return defaultValue
+	})
+	_ = request.Set("queryValues", func(name string, defaultValue goja.Value) goja.Value {
+		if values := c.req.query[name]; len(values) > 0 {
+			return c.vm.ToValue(values)
+		}
+		return defaultValue
+	})
+	return request
+}
+
+func (c *runtimeCall) newResponse() *goja.Object {
+	response := c.vm.NewObject()
+	finish := func(status int, body goja.Value) {
+		c.res.status = status
+		if body != nil && !goja.IsUndefined(body) && !goja.IsNull(body) {
+			c.setBody(body)
+		}
+		c.res.finished = true
+	}
+	_ = response.Set("status", func(value int) *goja.Object {
+		c.res.status = value
+		return response
+	})
+	_ = response.Set("body", func(value goja.Value) *goja.Object {
+		c.setBody(value)
+		return response
+	})
+	_ = response.Set("addHeader", func(key, value string) *goja.Object {
+		c.res.headers.Add(key, value)
+		return response
+	})
+	_ = response.Set("removeCookie", func(name string) *goja.Object {
+		c.res.headers.Add("Set-Cookie", (&http.Cookie{Name: name, Value: "", Path: "/", MaxAge: -1}).String())
+		return response
+	})
+	_ = response.Set("ok", func(body goja.Value) { finish(http.StatusOK, body) })
+	_ = response.Set("notFound", func(body goja.Value) { finish(http.StatusNotFound, body) })
+	_ = response.Set("badRequest", func(body goja.Value) { finish(http.StatusBadRequest, body) })
+	_ = response.Set("internalServerError", func(body goja.Value) { finish(http.StatusInternalServerError, body) })
+	_ = response.Set("noContent", func() { finish(http.StatusNoContent, nil) })
+	_ = response.Set("created", func(location goja.Value) {
+		if s, ok := location.Export().(string); ok {
+			c.res.headers.Set("Location", s)
+			finish(http.StatusCreated, nil)
+			return
+		}
+		finish(http.StatusCreated, location)
+	})
+	_ = response.Set("redirect", func(location string) {
+		c.res.headers.Set("Location", location)
+		finish(http.StatusFound, nil)
+	})
+	return response
+}
+
+// setBody sets the response body, serializing anything other than a string as JSON.
+func (c *runtimeCall) setBody(value goja.Value) {
+	if s, ok := value.Export().(string); ok {
+		c.res.body = []byte(s)
+		if c.res.headers.Get("Content-Type") == "" {
+			c.res.headers.Set("Content-Type", "text/plain; charset=utf-8")
+		}
+		return
+	}
+	stringify, _ := goja.AssertFunction(c.vm.Get("JSON").ToObject(c.vm).Get("stringify"))
+	body, err := stringify(goja.Undefined(), value)
+	if err != nil {
+		panic(err)
+	}
+	c.res.body = []byte(body.String())
+	c.res.headers.Set("Content-Type", "application/json")
+}
+
+type runtimeRoute struct {
+	method     string
+	path       string
+	middleware goja.Callable
+	handler    goja.Callable
+}
+
+type runtimeRouter struct {
+	routes    []runtimeRoute
+	useStatic bool
+
+	recording bool
+	// routedAt is the number of routes registered when router.route was first called, or -1.
+	routedAt int
+}
+
+func (c *runtimeCall) newRouter() *goja.Object {
+	c.router = &runtimeRouter{recording: c.recordRoutes, routedAt: -1}
+	router := c.vm.NewObject()
+	register := func(method string) func(goja.FunctionCall) goja.Value {
+		return func(call goja.FunctionCall) goja.Value {
+			route := runtimeRoute{method: method, path: call.Argument(0).String()}
+			handlers := make([]goja.Callable, 0, 2)
+			for i := 1; i < len(call.Arguments); i++ {
+				arg := call.Arguments[i]
+				fn, ok := goja.AssertFunction(arg)
+				if !ok {
+					panic(c.vm.NewTypeError("router.%s(%s) expects functions as handlers", strings.ToLower(method), route.path))
+				}
+				handlers = append(handlers, fn)
+			}
+			switch len(handlers) {
+			case 1:
+				route.handler = handlers[0]
+			case 2:
+				route.middleware, route.handler = handlers[0], handlers[1]
+			default:
+				panic(c.vm.NewTypeError("router.%s(%s) expects a handler and an optional middleware", strings.ToLower(method), route.path))
+			}
+			c.router.routes = append(c.router.routes, route)
+			return router
+		}
+	}
+	for _, method := range []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete, http.MethodOptions} {
+		_ = router.Set(strings.ToLower(method), register(method))
+	}
+	_ = router.Set("useStatic", func() *goja.Object {
+		c.router.useStatic = true
+		return router
+	})
+	_ = router.Set("route", func(namespace goja.Value) bool {
+		return c.route(namespace)
+	})
+	return router
+}
+
+// route runs the first route that matches the request. The handler isn't called when the
+// middleware returns false or finishes the response. Requests that don't match a route are
+// served from the static directory after router.useStatic is called.
+func (c *runtimeCall) route(namespace goja.Value) bool {
+	if c.router.routedAt < 0 {
+		c.router.routedAt = len(c.router.routes)
+	}
+	if c.router.recording {
+		return true
+	}
+	for _, route := range c.router.routes {
+		if route.method != c.req.method || !matchRoutePath(route.path, c.req.path) {
+			continue
+		}
+		if route.middleware != nil {
+			result, err := route.middleware(goja.Undefined(), namespace)
+			if err != nil {
+				panic(err)
+			}
+			if c.res.finished || (result != nil && result.StrictEquals(c.vm.ToValue(false))) {
+				return true
+			}
+		}
+		_, err := route.handler(goja.Undefined(), namespace)
+		if err != nil {
+			panic(err)
+		}
+		return true
+	}
+	if c.router.useStatic {
+		return c.serveStatic()
+	}
+	return false
+}
+
+// matchRoutePath matches a request path against a route path. Segments that start with a colon,
+// such as /users/:id, match any single segment.
+func matchRoutePath(routePath, requestPath string) bool {
+	routeSegments := strings.Split(strings.Trim(routePath, "/"), "/")
+	requestSegments := strings.Split(strings.Trim(requestPath, "/"), "/")
+	if len(routeSegments) != len(requestSegments) {
+		return false
+	}
+	for i, segment := range routeSegments {
+		if strings.HasPrefix(segment, ":") && requestSegments[i] != "" {
+			continue
+		}
+		if segment != requestSegments[i] {
+			return false
+		}
+	}
+	return true
+}
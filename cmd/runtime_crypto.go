@@ -0,0 +1,103 @@
+package cmd
+
+import (
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/base64"
+	"math/big"
+
+	"github.com/dop251/goja"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// The PBKDF2 parameters used by namespace.v1.crypto.hashPassword on the server. The key length is in bits.
+const (
+	passwordSaltLength     = 16
+	passwordIterationCount = 65536
+	passwordKeyLength      = 512
+)
+
+type hashPasswordResult struct {
+	Salt           string `json:"salt"`
+	PasswordHash   string `json:"passwordHash"`
+	IterationCount int    `json:"iterationCount"`
+	KeyLength      int    `json:"keyLength"`
+}
+
+// hashPassword derives a key from the password with PBKDF2-HMAC-SHA512 and a random salt.
+// The salt and hash are base64 encoded.
+func hashPassword(password string, iterationCount, keyLength int) (*hashPasswordResult, error) {
+	salt := make([]byte, passwordSaltLength)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return nil, err
+	}
+	return &hashPasswordResult{
+		Salt:           base64.StdEncoding.EncodeToString(salt),
+		PasswordHash:   base64.StdEncoding.EncodeToString(derivePasswordHash(password, salt, iterationCount, keyLength)),
+		IterationCount: iterationCount,
+		KeyLength:      keyLength,
+	}, nil
+}
+
+// derivePasswordHash derives a key of keyLength bits from the password with PBKDF2-HMAC-SHA512.
+func derivePasswordHash(password string, salt []byte, iterationCount, keyLength int) []byte {
+	return pbkdf2.Key([]byte(password), salt, iterationCount, keyLength/8, sha512.New)
+}
+
+// randomInteger returns a random integer between min and max, inclusive.
+func randomInteger(min, max int64) (int64, error) {
+	if max < min {
+		min, max = max, min
+	}
+	n, err := rand.Int(rand.Reader, big.NewInt(max-min+1))
+	if err != nil {
+		return 0, err
+	}
+	return min + n.Int64(), nil
+}
+
+// confirmationCode returns a random six digit code.
+func confirmationCode() (int64, error) {
+	return randomInteger(100000, 999999)
+}
+
+func (c *runtimeCall) newCrypto() *goja.Object {
+	crypto := c.vm.NewObject()
+	_ = crypto.Set("hashPassword", func(call goja.FunctionCall) goja.Value {
+		iterationCount := passwordIterationCount
+		keyLength := passwordKeyLength
+		if len(call.Arguments) >= 3 {
+			iterationCount = int(call.Argument(1).ToInteger())
+			keyLength = int(call.Argument(2).ToInteger())
+		}
+		if iterationCount < 1 || keyLength < 8 {
+			panic(c.vm.NewTypeError("hashPassword expects a positive iteration count and key length"))
+		}
+		result, err := hashPassword(call.Argument(0).String(), iterationCount, keyLength)
+		if err != nil {
+			panic(c.vm.NewGoError(err))
+		}
+		o := c.vm.NewObject()
+		_ = o.Set("salt", result.Salt)
+		_ = o.Set("passwordHash", result.PasswordHash)
+		_ = o.Set("iterationCount", result.IterationCount)
+		_ = o.Set("keyLength", result.KeyLength)
+		return o
+	})
+	_ = crypto.Set("randomInteger", func(min, max int64) int64 {
+		n, err := randomInteger(min, max)
+		if err != nil {
+			panic(c.vm.NewGoError(err))
+		}
+		return n
+	})
+	_ = crypto.Set("confirmationCode", func() int64 {
+		n, err := confirmationCode()
+		if err != nil {
+			panic(c.vm.NewGoError(err))
+		}
+		return n
+	})
+	return crypto
+}
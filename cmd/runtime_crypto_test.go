@@ -0,0 +1,70 @@
+package cmd
+
+import (
+	"encoding/base64"
+	"testing"
+)
+
+func TestDerivePasswordHash(t *testing.T) {
+	tests := []struct {
+		name           string
+		password       string
+		salt           string
+		iterationCount int
+		keyLength      int
+		want           string
+	}{
+		{
+			name:           "production parameters",
+			password:       "correct horse battery staple",
+			salt:           "AAECAwQFBgcICQoLDA0ODw==",
+			iterationCount: passwordIterationCount,
+			keyLength:      passwordKeyLength,
+			want:           "Hbm6TqOB4wrsDnzHTjsGdTPXAwDyVhaxZmxDMEw86UoQcpoLyGbE1yKm7DVKJgKP2t7v9M6/XxFKu3/QgEKf/Q==",
+		},
+		{
+			name:           "single iteration",
+			password:       "password",
+			salt:           base64.StdEncoding.EncodeToString([]byte("salt")),
+			iterationCount: 1,
+			keyLength:      512,
+			want:           "hn9wzxreAs/zdSWZo6U9xK80x6ZpgVrl1RNVThyM8lLALUcKKFoFAbrZmb/pQ8CPBQI119aLHaVeY/c7YKV/zg==",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			salt, err := base64.StdEncoding.DecodeString(tt.salt)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := base64.StdEncoding.EncodeToString(derivePasswordHash(tt.password, salt, tt.iterationCount, tt.keyLength))
+			if got != tt.want {
+				t.Errorf("derivePasswordHash() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHashPassword(t *testing.T) {
+	result, err := hashPassword("secret", passwordIterationCount, passwordKeyLength)
+	if err != nil {
+		t.Fatal(err)
+	}
+	salt, err := base64.StdEncoding.DecodeString(result.Salt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(salt) != passwordSaltLength {
+		t.Errorf("salt is %d bytes, want %d", len(salt), passwordSaltLength)
+	}
+	hash, err := base64.StdEncoding.DecodeString(result.PasswordHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hash)*8 != passwordKeyLength {
+		t.Errorf("hash is %d bits, want %d", len(hash)*8, passwordKeyLength)
+	}
+	if want := base64.StdEncoding.EncodeToString(derivePasswordHash("secret", salt, passwordIterationCount, passwordKeyLength)); result.PasswordHash != want {
+		t.Errorf("passwordHash = %s, want %s", result.PasswordHash, want)
+	}
+}
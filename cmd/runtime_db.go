@@ -0,0 +1,213 @@
+package cmd
+
+import (
+	"database/sql"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dop251/goja"
+	_ "modernc.org/sqlite"
+)
+
+// openDB returns the SQLite database used for a connection string. Connection strings that start
+// with "sqlite:" name their own database file, below sqliteDir when it is set, every other
+// connection string, such as the Postgres connection used in production, shares the dev database.
+func (rt *devRuntime) openDB(connStr string) (*sql.DB, error) {
+	dbPath := rt.dbPath
+	if strings.HasPrefix(connStr, "sqlite:") {
+		dbPath = strings.TrimPrefix(connStr, "sqlite:")
+		if rt.sqliteDir != "" {
+			dbPath = filepath.Join(rt.sqliteDir, strings.TrimPrefix(dbPath, filepath.VolumeName(dbPath)))
+		}
+	}
+	rt.dbMu.Lock()
+	defer rt.dbMu.Unlock()
+	if db, ok := rt.dbs[dbPath]; ok {
+		return db, nil
+	}
+	if dir := filepath.Dir(dbPath); dir != "" {
+		err := os.MkdirAll(dir, os.FileMode(0755))
+		if err != nil {
+			return nil, err
+		}
+	}
+	db, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		return nil, err
+	}
+	// SQLite allows a single writer, sharing one connection avoids "database is locked" errors
+	db.SetMaxOpenConns(1)
+	rt.dbs[dbPath] = db
+	return db, nil
+}
+
+// db implements namespace.v1.db(connStr).
+func (c *runtimeCall) db(connStr string) *goja.Object {
+	var db *sql.DB
+	if !c.recordRoutes {
+		var err error
+		db, err = c.rt.openDB(connStr)
+		if err != nil {
+			panic(c.vm.NewGoError(err))
+		}
+	}
+	connection := c.vm.NewObject()
+	_ = connection.Set("statement", func(call goja.FunctionCall) goja.Value {
+		query := ""
+		if s := call.Argument(0); !goja.IsUndefined(s) {
+			query = s.String()
+		}
+		return c.newStatement(db, query)
+	})
+	return connection
+}
+
+// newStatement returns a statement of db. Without a db, while routes are recorded, statements
+// change nothing and return no rows.
+func (c *runtimeCall) newStatement(db *sql.DB, query string) *goja.Object {
+	args := make([]interface{}, 0)
+	statement := c.vm.NewObject()
+	bind := func(convert func(goja.Value) interface{}) func(goja.Value) *goja.Object {
+		return func(value goja.Value) *goja.Object {
+			if goja.IsUndefined(value) || goja.IsNull(value) {
+				args = append(args, nil)
+			} else {
+				args = append(args, convert(value))
+			}
+			return statement
+		}
+	}
+	_ = statement.Set("setSQL", func(s string) *goja.Object {
+		query = s
+		return statement
+	})
+	_ = statement.Set("setString", bind(func(v goja.Value) interface{} { return v.String() }))
+	_ = statement.Set("setNumber", bind(func(v goja.Value) interface{} {
+		f := v.ToFloat()
+		if f == math.Trunc(f) && math.Abs(f) < 1<<53 {
+			return int64(f)
+		}
+		return f
+	}))
+	_ = statement.Set("setBoolean", bind(func(v goja.Value) interface{} { return v.ToBoolean() }))
+	_ = statement.Set("setDate", bind(func(v goja.Value) interface{} {
+		t, ok := v.Export().(time.Time)
+		if !ok {
+			panic(c.vm.NewTypeError("setDate expects a Date"))
+		}
+		return t.UTC()
+	}))
+	_ = statement.Set("execute", func() *goja.Object {
+		if db == nil {
+			o := c.vm.NewObject()
+			_ = o.Set("rowsAffected", 0)
+			_ = o.Set("lastInsertId", "0")
+			return o
+		}
+		result, err := db.Exec(query, args...)
+		if err != nil {
+			panic(c.vm.NewGoError(err))
+		}
+		rowsAffected, _ := result.RowsAffected()
+		lastInsertID, _ := result.LastInsertId()
+		o := c.vm.NewObject()
+		_ = o.Set("rowsAffected", rowsAffected)
+		_ = o.Set("lastInsertId", strconv.FormatInt(lastInsertID, 10))
+		return o
+	})
+	_ = statement.Set("query", func() goja.Value {
+		if db == nil {
+			return c.vm.NewArray()
+		}
+		rows, err := c.queryRows(db, query, args, -1)
+		if err != nil {
+			panic(c.vm.NewGoError(err))
+		}
+		return c.vm.ToValue(rows)
+	})
+	_ = statement.Set("queryOne", func() goja.Value {
+		if db == nil {
+			return goja.Null()
+		}
+		rows, err := c.queryRows(db, query, args, 1)
+		if err != nil {
+			panic(c.vm.NewGoError(err))
+		}
+		if len(rows) == 0 {
+			return goja.Null()
+		}
+		return rows[0]
+	})
+	_ = statement.Set("queryScalar", func() goja.Value {
+		if db == nil {
+			return goja.Null()
+		}
+		var value interface{}
+		err := db.QueryRow(query, args...).Scan(&value)
+		if err == sql.ErrNoRows {
+			return goja.Null()
+		}
+		if err != nil {
+			panic(c.vm.NewGoError(err))
+		}
+		return c.toJSValue(value)
+	})
+	return statement
+}
+
+// queryRows returns up to limit rows as objects keyed by column name, all rows when limit is negative.
+func (c *runtimeCall) queryRows(db *sql.DB, query string, args []interface{}, limit int) ([]*goja.Object, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+	columns, err := rows.ColumnTypes()
+	if err != nil {
+		return nil, err
+	}
+	result := make([]*goja.Object, 0)
+	for (limit < 0 || len(result) < limit) && rows.Next() {
+		values := make([]interface{}, len(columns))
+		pointers := make([]interface{}, len(columns))
+		for i := range values {
+			pointers[i] = &values[i]
+		}
+		err = rows.Scan(pointers...)
+		if err != nil {
+			return nil, err
+		}
+		row := c.vm.NewObject()
+		for i, column := range columns {
+			value := values[i]
+			// SQLite stores booleans as integers, return them as booleans like production does
+			if n, ok := value.(int64); ok && strings.EqualFold(column.DatabaseTypeName(), "BOOLEAN") {
+				value = n != 0
+			}
+			_ = row.Set(column.Name(), c.toJSValue(value))
+		}
+		result = append(result, row)
+	}
+	return result, rows.Err()
+}
+
+func (c *runtimeCall) toJSValue(value interface{}) goja.Value {
+	switch v := value.(type) {
+	case nil:
+		return goja.Null()
+	case []byte:
+		return c.vm.ToValue(string(v))
+	case time.Time:
+		date, err := c.vm.New(c.vm.Get("Date"), c.vm.ToValue(v.UnixNano()/int64(time.Millisecond)))
+		if err != nil {
+			panic(err)
+		}
+		return date
+	default:
+		return c.vm.ToValue(v)
+	}
+}
@@ -0,0 +1,63 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/dop251/goja"
+)
+
+// newMail implements namespace.v1.mail. Instead of connecting to an SMTP server, sessions write
+// every message to the mailbox directory as an .eml file. Nothing is written while routes are
+// recorded.
+func (c *runtimeCall) newMail() *goja.Object {
+	mail := c.vm.NewObject()
+	_ = mail.Set("connect", func(host string, auth bool, port int, username, password string) *goja.Object {
+		session := c.vm.NewObject()
+		_ = session.Set("send", func(from, to, subject, body string) {
+			if c.recordRoutes {
+				return
+			}
+			id, err := writeMail(c.rt.mailDir, fmt.Sprintf("%s:%d", host, port), from, to, subject, body)
+			if err != nil {
+				panic(c.vm.NewGoError(err))
+			}
+			p("mail", "captured message %s to %s\n", id, to)
+		})
+		return session
+	})
+	return mail
+}
+
+func writeMail(mailDir, server, from, to, subject, body string) (string, error) {
+	err := os.MkdirAll(mailDir, os.FileMode(0755))
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	id := now.UTC().Format("20060102T150405.000000000")
+	contentType := "text/plain; charset=utf-8"
+	if strings.HasPrefix(strings.TrimSpace(body), "<") {
+		contentType = "text/html; charset=utf-8"
+	}
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "Message-ID: <%s@cavemark.local>\r\n", id)
+	fmt.Fprintf(&msg, "Date: %s\r\n", now.Format(time.RFC1123Z))
+	fmt.Fprintf(&msg, "From: %s\r\n", from)
+	fmt.Fprintf(&msg, "To: %s\r\n", to)
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&msg, "X-Cavemark-Server: %s\r\n", server)
+	fmt.Fprintf(&msg, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&msg, "Content-Type: %s\r\n", contentType)
+	fmt.Fprintf(&msg, "Content-Transfer-Encoding: 8bit\r\n")
+	fmt.Fprintf(&msg, "\r\n%s", body)
+
+	return id, ioutil.WriteFile(filepath.Join(mailDir, id+".eml"), msg.Bytes(), os.FileMode(0644))
+}
@@ -0,0 +1,121 @@
+package cmd
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/dop251/goja"
+	"golang.org/x/text/currency"
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+	"golang.org/x/text/number"
+)
+
+const (
+	defaultMoneyLanguage = "en"
+	defaultMoneyCountry  = "US"
+)
+
+// currencyPatterns are the CLDR standard currency patterns of the locales that don't use the
+// pattern of their language, and of the languages. ¤ is the currency symbol, - the minus sign of
+// the locale and # the number, and a pattern without a negative part puts the minus sign first.
+var currencyPatterns = map[string]string{
+	"":      "¤\u00a0#",
+	"cs":    "#\u00a0¤",
+	"da":    "#\u00a0¤",
+	"de":    "#\u00a0¤",
+	"de-AT": "¤\u00a0#",
+	"de-CH": "¤\u00a0#;¤-#",
+	"en":    "¤#",
+	"es":    "#\u00a0¤",
+	"es-MX": "¤#",
+	"es-US": "¤#",
+	"fi":    "#\u00a0¤",
+	"fr":    "#\u00a0¤",
+	"it":    "#\u00a0¤",
+	"it-CH": "¤\u00a0#;¤-#",
+	"ja":    "¤#",
+	"ko":    "¤#",
+	"nb":    "#\u00a0¤",
+	"nl":    "¤\u00a0#;¤\u00a0-#",
+	"pl":    "#\u00a0¤",
+	"pt":    "¤\u00a0#",
+	"pt-PT": "#\u00a0¤",
+	"ru":    "#\u00a0¤",
+	"sv":    "#\u00a0¤",
+	"tr":    "¤#",
+	"zh":    "¤#",
+}
+
+// currencyPattern returns the currency pattern of a locale, falling back to its language and to
+// the CLDR root locale.
+func currencyPattern(lang, country string) string {
+	if pattern, ok := currencyPatterns[lang+"-"+country]; ok {
+		return pattern
+	}
+	if pattern, ok := currencyPatterns[lang]; ok {
+		return pattern
+	}
+	return currencyPatterns[""]
+}
+
+// formatMoney formats value in the currency of country, rounded to the digits of the currency,
+// using the number format and currency pattern of language, e.g. "-$1,234.50" in en-US and
+// "1.234,50 €" in de-DE.
+func formatMoney(value float64, lang, country string) (string, error) {
+	tag, err := language.Parse(lang + "-" + country)
+	if err != nil {
+		return "", fmt.Errorf("locale (%s-%s) not supported: %w", lang, country, err)
+	}
+	region, err := language.ParseRegion(country)
+	if err != nil {
+		return "", fmt.Errorf("country (%s) not supported: %w", country, err)
+	}
+	unit, ok := currency.FromRegion(region)
+	if !ok {
+		return "", fmt.Errorf("country (%s) has no currency", country)
+	}
+	base, _ := tag.Base()
+	lang, country = base.String(), region.String()
+	printer := message.NewPrinter(tag)
+	scale, _ := currency.Standard.Rounding(unit)
+
+	// round half away from zero like Intl.NumberFormat, the number package rounds half to even
+	pow := math.Pow10(scale)
+	abs := math.Round(math.Abs(value)*pow) / pow
+	formatted := printer.Sprint(number.Decimal(abs, number.Scale(scale)))
+	pattern := currencyPattern(lang, country)
+	if value < 0 && abs > 0 {
+		minus := strings.TrimSuffix(printer.Sprint(number.Decimal(-abs, number.Scale(scale))), formatted)
+		if i := strings.Index(pattern, ";"); i >= 0 {
+			pattern = strings.Replace(pattern[i+1:], "-", minus, 1)
+		} else {
+			pattern = minus + pattern
+		}
+	} else if i := strings.Index(pattern, ";"); i >= 0 {
+		pattern = pattern[:i]
+	}
+	symbol := printer.Sprint(currency.Symbol(unit))
+	return strings.NewReplacer("¤", symbol, "#", formatted).Replace(pattern), nil
+}
+
+func (c *runtimeCall) newMoney() *goja.Object {
+	money := c.vm.NewObject()
+	_ = money.Set("formatted", func(call goja.FunctionCall) goja.Value {
+		lang := defaultMoneyLanguage
+		country := defaultMoneyCountry
+		if len(call.Arguments) >= 2 {
+			lang = call.Argument(1).String()
+		}
+		if len(call.Arguments) >= 3 {
+			country = call.Argument(2).String()
+		}
+		formatted, err := formatMoney(call.Argument(0).ToFloat(), lang, country)
+		if err != nil {
+			panic(c.vm.NewGoError(err))
+		}
+		return c.vm.ToValue(formatted)
+	})
+	return money
+}
@@ -0,0 +1,37 @@
+package cmd
+
+import "testing"
+
+func TestFormatMoney(t *testing.T) {
+	tests := []struct {
+		value   float64
+		lang    string
+		country string
+		want    string
+	}{
+		{1234.5, "en", "US", "$1,234.50"},
+		{-1234.5, "en", "US", "-$1,234.50"},
+		{0.005, "en", "US", "$0.01"},
+		{-0.001, "en", "US", "$0.00"},
+		{1234.5, "de", "DE", "1.234,50\u00a0€"},
+		{-1234.5, "de", "DE", "-1.234,50\u00a0€"},
+		{1234.5, "de", "CH", "CHF\u00a01’234.50"},
+		{-1234.5, "de", "CH", "CHF-1’234.50"},
+		{1234.5, "ja", "JP", "￥1,235"},
+		{-1234.5, "ja", "JP", "-￥1,235"},
+	}
+	for _, tt := range tests {
+		got, err := formatMoney(tt.value, tt.lang, tt.country)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("formatMoney(%v, %s, %s) = %q, want %q", tt.value, tt.lang, tt.country, got, tt.want)
+		}
+	}
+	for _, country := range []string{"XX", "AQ"} {
+		if _, err := formatMoney(1, "en", country); err == nil {
+			t.Errorf("formatMoney(1, en, %s) should fail", country)
+		}
+	}
+}
@@ -0,0 +1,31 @@
+package cmd
+
+import (
+	"net/http"
+
+	"github.com/dop251/goja"
+)
+
+// newMustache implements namespace.v1.mustache using the templates in the resource directory.
+func (c *runtimeCall) newMustache() *goja.Object {
+	mustache := c.vm.NewObject()
+	execute := func(name string, scope goja.Value) string {
+		var context interface{}
+		if scope != nil && !goja.IsUndefined(scope) {
+			context = scope.Export()
+		}
+		out, err := renderTemplate(resourceDir, name, context)
+		if err != nil {
+			panic(c.vm.NewGoError(err))
+		}
+		return out
+	}
+	_ = mustache.Set("execute", execute)
+	_ = mustache.Set("html", func(name string, scope goja.Value) {
+		c.res.body = []byte(execute(name, scope))
+		c.res.headers.Set("Content-Type", "text/html; charset=utf-8")
+		c.res.status = http.StatusOK
+		c.res.finished = true
+	})
+	return mustache
+}
@@ -0,0 +1,114 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"net/http"
+	neturl "net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/pflag"
+)
+
+// addStaticRuleFlags registers the flags that change how static files are served, for the
+// commands that serve them locally, so dev, replay and test serve them the same way.
+func addStaticRuleFlags(flags *pflag.FlagSet) {
+	addContentTypeFlags(flags)
+	addHeadersFlags(flags)
+	addRedirectsFlags(flags)
+}
+
+// serveStatic responds with the static file for the request path, the same way router.useStatic
+// serves the files uploaded by deployStatics. The rules of the _redirects file are applied first.
+// While the redirect or header rules are invalid, requests fail with the error.
+func (c *runtimeCall) serveStatic() bool {
+	if c.req.method != http.MethodGet && c.req.method != http.MethodHead {
+		return false
+	}
+	c.rt.mu.RLock()
+	redirects, headers, err, ignore := c.rt.redirects, c.rt.headerRules, c.rt.rulesErr, c.rt.staticIgnore
+	c.rt.mu.RUnlock()
+	if err != nil {
+		c.res.status = http.StatusInternalServerError
+		c.res.headers.Set("Content-Type", "text/plain; charset=utf-8")
+		c.res.body = []byte(err.Error())
+		c.res.finished = true
+		return true
+	}
+	f, exists := staticFileFor(c.req.path, ignore)
+	if target, ok := redirects.normalizeTrailingSlash(c.req.path, exists); ok {
+		return c.redirectStatic(http.StatusMovedPermanently, target)
+	}
+	rule, params, ok := redirects.match(c.req.path, func(string) bool { return exists })
+	if !ok {
+		return exists && c.serveStaticFile(f, http.StatusOK, headers)
+	}
+	target := expandRedirectTarget(rule.To, params)
+	if rule.isRedirect() {
+		return c.redirectStatic(rule.Status, target)
+	}
+	f, exists = staticFileFor(strings.SplitN(target, "?", 2)[0], ignore)
+	return exists && c.serveStaticFile(f, rule.Status, headers)
+}
+
+func (c *runtimeCall) serveStaticFile(f string, status int, rules headerRules) bool {
+	contents, err := ioutil.ReadFile(f)
+	if err != nil {
+		return false
+	}
+	contentType, err := contentTypeOf(f, contents)
+	if err != nil {
+		return false
+	}
+	filePath, err := filepath.Rel(staticDir, f)
+	if err != nil {
+		return false
+	}
+	for key, value := range rules.match(filepath.ToSlash(filePath)) {
+		c.res.headers.Set(key, value)
+	}
+	c.res.status = status
+	c.res.headers.Set("Content-Type", contentType)
+	c.res.body = contents
+	c.res.finished = true
+	return true
+}
+
+// redirectStatic redirects to target and keeps the query string of the request, unless target
+// has its own.
+func (c *runtimeCall) redirectStatic(status int, target string) bool {
+	if len(c.req.query) > 0 && !strings.Contains(target, "?") {
+		target += "?" + neturl.Values(c.req.query).Encode()
+	}
+	c.res.status = status
+	c.res.headers.Set("Location", target)
+	c.res.finished = true
+	return true
+}
+
+// staticFileFor maps a request path to a file in the static directory. Like deployStatics, a file
+// is served under its path relative to the static directory, and hidden files and files ignored
+// by .cavemarkignore files aren't served.
+func staticFileFor(requestPath string, ignore *ignoreMatcher) (string, bool) {
+	if staticDir == "" {
+		return "", false
+	}
+	filePath := strings.TrimPrefix(path.Clean("/"+requestPath), "/")
+	if filePath == "" || strings.HasPrefix(path.Base(filePath), ".") {
+		return "", false
+	}
+	f := filepath.Join(staticDir, filepath.FromSlash(filePath))
+	info, err := os.Stat(f)
+	if err != nil || info.IsDir() {
+		return "", false
+	}
+	if ignore != nil {
+		ignored, _, err := ignore.ignored(f, false)
+		if err != nil || ignored {
+			return "", false
+		}
+	}
+	return f, true
+}
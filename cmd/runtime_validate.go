@@ -0,0 +1,155 @@
+package cmd
+
+import (
+	"fmt"
+	"net/mail"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/dop251/goja"
+)
+
+// validation implements the namespace.v1.validate chain. Rules apply to the value of the last
+// call to that(). Rules other than isRequired pass for empty values, so optional fields can be
+// validated without also being required. check() returns the first failed message of each key.
+type validation struct {
+	keys   []string
+	errors map[string]string
+	key    string
+	value  string
+	// lastRecorded is set when the previous rule recorded the message of the key, which msg replaces
+	lastRecorded bool
+}
+
+func newValidation() *validation {
+	return &validation{errors: make(map[string]string)}
+}
+
+func (v *validation) that(key, value string) {
+	v.key = key
+	v.value = value
+	v.lastRecorded = false
+	v.keys = append(v.keys, key)
+}
+
+// rule records message for the current key when ok is false. Only the first failure of a key is kept.
+func (v *validation) rule(ok bool, message string) {
+	v.lastRecorded = false
+	if ok {
+		return
+	}
+	if _, exists := v.errors[v.key]; !exists {
+		v.errors[v.key] = message
+		v.lastRecorded = true
+	}
+}
+
+// msg replaces the message of the previous rule when that rule recorded the failure of the key. A
+// rule that failed after an earlier rule of the same key doesn't change the recorded message.
+func (v *validation) msg(message string) {
+	if v.lastRecorded {
+		v.errors[v.key] = message
+	}
+}
+
+func (v *validation) isRequired(message string) {
+	v.rule(strings.TrimSpace(v.value) != "", orDefault(message, fmt.Sprintf("%s is required", v.key)))
+}
+
+func (v *validation) isBetween(min, max int, message string) {
+	n := utf8.RuneCountInString(v.value)
+	v.rule(v.value == "" || (n >= min && n <= max), orDefault(message, fmt.Sprintf("%s must be between %d and %d characters", v.key, min, max)))
+}
+
+func (v *validation) isEmail(message string) {
+	address, err := mail.ParseAddress(v.value)
+	v.rule(v.value == "" || (err == nil && address.Address == v.value), orDefault(message, fmt.Sprintf("%s must be a valid email address", v.key)))
+}
+
+func (v *validation) has(test func(rune) bool, message string) {
+	v.rule(v.value == "" || strings.IndexFunc(v.value, test) >= 0, message)
+}
+
+func (v *validation) check() map[string]string {
+	errors := v.errors
+	*v = *newValidation()
+	return errors
+}
+
+func isSpecial(r rune) bool {
+	return !unicode.IsLetter(r) && !unicode.IsDigit(r) && !unicode.IsSpace(r)
+}
+
+func orDefault(message, defaultMessage string) string {
+	if message == "" {
+		return defaultMessage
+	}
+	return message
+}
+
+func (c *runtimeCall) newValidate() *goja.Object {
+	v := newValidation()
+	validate := c.vm.NewObject()
+	message := func(value goja.Value) string {
+		if value == nil || goja.IsUndefined(value) || goja.IsNull(value) {
+			return ""
+		}
+		return value.String()
+	}
+	_ = validate.Set("that", func(key string, value goja.Value) *goja.Object {
+		v.that(key, message(value))
+		return validate
+	})
+	_ = validate.Set("isRequired", func(msg goja.Value) *goja.Object {
+		v.isRequired(message(msg))
+		return validate
+	})
+	_ = validate.Set("isBetween", func(min, max int, msg goja.Value) *goja.Object {
+		v.isBetween(min, max, message(msg))
+		return validate
+	})
+	_ = validate.Set("isEmail", func(msg goja.Value) *goja.Object {
+		v.isEmail(message(msg))
+		return validate
+	})
+	_ = validate.Set("hasLower", func(msg goja.Value) *goja.Object {
+		v.has(unicode.IsLower, orDefault(message(msg), fmt.Sprintf("%s must contain a lowercase letter", v.key)))
+		return validate
+	})
+	_ = validate.Set("hasUpper", func(msg goja.Value) *goja.Object {
+		v.has(unicode.IsUpper, orDefault(message(msg), fmt.Sprintf("%s must contain an uppercase letter", v.key)))
+		return validate
+	})
+	_ = validate.Set("hasDigit", func(msg goja.Value) *goja.Object {
+		v.has(unicode.IsDigit, orDefault(message(msg), fmt.Sprintf("%s must contain a digit", v.key)))
+		return validate
+	})
+	_ = validate.Set("hasSpecial", func(call goja.FunctionCall) goja.Value {
+		// hasSpecial(message?) or hasSpecial(specialCharacters, message?)
+		test, msg := isSpecial, call.Argument(0)
+		if len(call.Arguments) >= 2 {
+			specialCharacters := call.Argument(0).String()
+			test = func(r rune) bool { return strings.ContainsRune(specialCharacters, r) }
+			msg = call.Argument(1)
+		}
+		v.has(test, orDefault(message(msg), fmt.Sprintf("%s must contain a special character", v.key)))
+		return validate
+	})
+	_ = validate.Set("msg", func(msg string) *goja.Object {
+		v.msg(msg)
+		return validate
+	})
+	_ = validate.Set("check", func() *goja.Object {
+		keys := v.keys
+		errors := v.check()
+		result := c.vm.NewObject()
+		for _, key := range keys {
+			if message, ok := errors[key]; ok {
+				_ = result.Set(key, message)
+			}
+		}
+		return result
+	})
+	return validate
+}
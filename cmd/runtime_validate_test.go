@@ -0,0 +1,74 @@
+package cmd
+
+import (
+	"reflect"
+	"testing"
+	"unicode"
+)
+
+func TestValidationMessages(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		rules func(v *validation)
+		want  map[string]string
+	}{
+		{
+			name:  "msg replaces the failed rule",
+			value: "abc",
+			rules: func(v *validation) {
+				v.has(unicode.IsUpper, "upper")
+				v.msg("need an uppercase letter")
+			},
+			want: map[string]string{"password": "need an uppercase letter"},
+		},
+		{
+			name:  "msg of a later failed rule keeps the first message",
+			value: "abc",
+			rules: func(v *validation) {
+				v.has(unicode.IsUpper, "upper")
+				v.has(unicode.IsDigit, "digit")
+				v.msg("need a digit")
+			},
+			want: map[string]string{"password": "upper"},
+		},
+		{
+			name:  "msg of a passed rule",
+			value: "Abc",
+			rules: func(v *validation) {
+				v.has(unicode.IsUpper, "upper")
+				v.msg("need an uppercase letter")
+				v.has(unicode.IsDigit, "digit")
+			},
+			want: map[string]string{"password": "digit"},
+		},
+		{
+			name:  "optional empty value",
+			value: "",
+			rules: func(v *validation) {
+				v.isBetween(8, 64, "")
+				v.isEmail("")
+			},
+			want: map[string]string{},
+		},
+		{
+			name:  "required",
+			value: " ",
+			rules: func(v *validation) {
+				v.isRequired("")
+				v.msg("enter a password")
+			},
+			want: map[string]string{"password": "enter a password"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := newValidation()
+			v.that("password", tt.value)
+			tt.rules(v)
+			if got := v.check(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("check() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,235 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// cliProtocolVersion is the version of the deployment protocol the cli speaks. It is increased
+// whenever the endpoints under the API base path change in a way older servers don't understand.
+const cliProtocolVersion = 1
+
+const defaultAPIBasePath = "/cvmrk/cli"
+
+// features a server can advertise in its info
+const (
+	featureBatch         = "batch"
+	featureChunkedUpload = "chunked-upload"
+	featureDelete        = "delete"
+	featureHeaders       = "headers"
+	featurePreview       = "preview"
+)
+
+// previewHeader routes a request to a deployment that isn't active, on servers that support
+// previews without a preview URL.
+const previewHeader = "X-Cavemark-Deploy-Key"
+
+// serverInfo is the response of GET /cvmrk/cli/info, which describes what the server supports.
+type serverInfo struct {
+	Version  string `json:"version"`
+	Protocol struct {
+		Min int `json:"min"`
+		Max int `json:"max"`
+	} `json:"protocol"`
+	MinCLIVersion string   `json:"minCliVersion"`
+	BasePath      string   `json:"basePath"`
+	Features      []string `json:"features"`
+	// PreviewURL is the URL of a deployment that isn't active, with {deployKey} in place of its
+	// key, e.g. https://{deployKey}.preview.example.com
+	PreviewURL string `json:"previewUrl"`
+	Limits     struct {
+		// MaxFileSize is the largest resource or static file the server accepts, 0 for no limit
+		MaxFileSize int64 `json:"maxFileSize"`
+		// MaxRequestSize is the largest request body the server accepts, 0 for no limit
+		MaxRequestSize int64 `json:"maxRequestSize"`
+	} `json:"limits"`
+}
+
+// server holds the capabilities of the server once negotiate is called. Until then, and for
+// servers without the info endpoint, the server is assumed to support protocol 1 without any
+// optional features.
+var server = legacyServerInfo()
+
+func legacyServerInfo() *serverInfo {
+	info := &serverInfo{BasePath: defaultAPIBasePath, Features: []string{}}
+	info.Protocol.Min = 1
+	info.Protocol.Max = 1
+	return info
+}
+
+// negotiate fetches the capabilities of the server and fails when the server and the cli can't
+// work together.
+func negotiate(version string) error {
+	resp, err := httpGet(fmt.Sprintf("%s%s/info", url, defaultAPIBasePath))
+	if err != nil {
+		return fmt.Errorf("error getting server info: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		server = legacyServerInfo()
+		p("server", "the server doesn't describe its capabilities, assuming protocol 1 without optional features\n")
+		return nil
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return fmt.Errorf("the server rejected the api key or api secret key: status code = %d", resp.StatusCode)
+	default:
+		return fmt.Errorf("error getting server info: status code = %d", resp.StatusCode)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("error getting server info: %w", err)
+	}
+	info := legacyServerInfo()
+	err = json.Unmarshal(body, info)
+	if err != nil {
+		return fmt.Errorf("error reading server info, is %s a Cavemark server? %w", url, err)
+	}
+	if info.BasePath == "" {
+		info.BasePath = defaultAPIBasePath
+	}
+	info.BasePath = "/" + strings.Trim(info.BasePath, "/")
+	if cliProtocolVersion < info.Protocol.Min {
+		return fmt.Errorf("the server speaks protocol %d to %d and this cli speaks protocol %d, please upgrade the cli", info.Protocol.Min, info.Protocol.Max, cliProtocolVersion)
+	}
+	if cliProtocolVersion > info.Protocol.Max {
+		return fmt.Errorf("the server speaks protocol %d to %d and this cli speaks protocol %d, please use an older cli or upgrade the server", info.Protocol.Min, info.Protocol.Max, cliProtocolVersion)
+	}
+	if info.MinCLIVersion != "" && compareVersions(version, info.MinCLIVersion) < 0 {
+		return fmt.Errorf("the server requires cli version %s or later and this is version %s, please upgrade the cli", info.MinCLIVersion, version)
+	}
+	server = info
+	features := "none"
+	if len(info.Features) > 0 {
+		features = strings.Join(info.Features, ", ")
+	}
+	p("server", "version %s, protocol %d, features: %s\n", info.Version, cliProtocolVersion, features)
+	return nil
+}
+
+func (s *serverInfo) supports(feature string) bool {
+	for _, f := range s.Features {
+		if f == feature {
+			return true
+		}
+	}
+	return false
+}
+
+// checkFileSize fails for files larger than the server accepts.
+func (s *serverInfo) checkFileSize(f string, size int64) error {
+	if s.Limits.MaxFileSize > 0 && size > s.Limits.MaxFileSize {
+		return fmt.Errorf("file (%s) is %s and the server accepts files of at most %s", f, formatByteSize(size), formatByteSize(s.Limits.MaxFileSize))
+	}
+	return nil
+}
+
+// previewTarget returns where to send checks to reach a deployment before it is activated: the
+// preview URL of the server, or --url. Both get a header with the deployment key and it is up to
+// the server to authorize them. The api keys are never sent, since checks reach the functions of
+// the deployment and the preview URL is chosen by the server.
+func (s *serverInfo) previewTarget(deployKey string) checkTarget {
+	headers := make(http.Header)
+	headers.Set(previewHeader, deployKey)
+	if s.PreviewURL != "" {
+		return checkTarget{base: strings.ReplaceAll(s.PreviewURL, "{deployKey}", deployKey), headers: headers, relativeOnly: true}
+	}
+	return checkTarget{base: url, headers: headers, relativeOnly: true}
+}
+
+// checkFileSizes fails before a deployment begins when a resource or static file is larger than
+// the server accepts.
+func checkFileSizes() error {
+	if server.Limits.MaxFileSize <= 0 {
+		return nil
+	}
+	if deployArtifact != "" {
+		r, err := openArtifact(deployArtifact)
+		if err != nil {
+			return err
+		}
+		defer func() { _ = r.Close() }()
+		for _, f := range r.manifest.Files {
+			if f.Kind == "resource" || f.Kind == "static" {
+				err = server.checkFileSize(f.Kind+"/"+f.Path, f.Size)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	for _, kd := range [][2]string{{"resource", resourceDir}, {"static", staticDir}} {
+		kind, dir := kd[0], kd[1]
+		if dir == "" {
+			continue
+		}
+		_, err := os.Lstat(dir)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return err
+		}
+		files, err := globAll(dir)
+		if err != nil {
+			return fmt.Errorf("error globbing files: %w", err)
+		}
+		for _, f := range files {
+			info, err := os.Stat(f)
+			if err != nil {
+				return err
+			}
+			err = server.checkFileSize(kind+"/"+filepath.ToSlash(removeDir(f, dir)), info.Size())
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// apiURL returns the URL of an endpoint under the API base path of the server.
+func apiURL(format string, args ...interface{}) string {
+	return url + server.BasePath + fmt.Sprintf(format, args...)
+}
+
+// compareVersions compares two dotted version numbers such as 1.1.4, ignoring a leading v and
+// anything after a - or +. It returns -1, 0 or 1.
+func compareVersions(a, b string) int {
+	as, bs := versionParts(a), versionParts(b)
+	for i := 0; i < len(as) || i < len(bs); i++ {
+		var x, y int
+		if i < len(as) {
+			x = as[i]
+		}
+		if i < len(bs) {
+			y = bs[i]
+		}
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+	}
+	return 0
+}
+
+func versionParts(v string) []int {
+	v = strings.TrimPrefix(strings.TrimSpace(v), "v")
+	v = strings.SplitN(strings.SplitN(v, "-", 2)[0], "+", 2)[0]
+	parts := make([]int, 0, 3)
+	for _, s := range strings.Split(v, ".") {
+		n, _ := strconv.Atoi(s)
+		parts = append(parts, n)
+	}
+	return parts
+}
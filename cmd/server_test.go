@@ -0,0 +1,36 @@
+package cmd
+
+import (
+	"testing"
+)
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"1.1.4", "1.1.4", 0},
+		{"1.1.4", "1.1.5", -1},
+		{"1.2.0", "1.1.9", 1},
+		{"1.10.0", "1.9.0", 1},
+		{"2", "1.9.9", 1},
+		{"1.1", "1.1.0", 0},
+		{"1.1", "1.1.1", -1},
+		{"v1.2.3", "1.2.3", 0},
+		{" 1.2.3 ", "1.2.3", 0},
+		{"1.2.3-beta.1", "1.2.3", 0},
+		{"1.2.3+build.5", "1.2.4", -1},
+		{"1.x.0", "1.0.0", 0},
+		{"", "0.0.1", -1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.a+" "+tt.b, func(t *testing.T) {
+			if got := compareVersions(tt.a, tt.b); got != tt.want {
+				t.Errorf("compareVersions(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+			}
+			if got := compareVersions(tt.b, tt.a); got != -tt.want {
+				t.Errorf("compareVersions(%q, %q) = %d, want %d", tt.b, tt.a, got, -tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,554 @@
+package cmd
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	neturl "net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dop251/goja"
+	"github.com/spf13/cobra"
+)
+
+var (
+	testJUnit string
+)
+
+const (
+	cavemarkTestJUnit = "CAVEMARK_TEST_JUNIT"
+)
+
+var testFileSuffixes = []string{".test.js", ".test.ts"}
+
+var testCmd = &cobra.Command{
+	Use:          "test [files...]",
+	Short:        "run function tests locally",
+	SilenceUsage: true,
+	Long: `Runs the tests in the function directory against the emulated runtime.
+
+Every *.test.js (or *.test.ts) file in the function directory is bundled the same way as the
+function and runs with the following globals:
+
+  test(name, fn)                      registers a test, fn may return a promise
+  assert.ok(value, message?)          fails unless value is truthy
+  assert.equal(actual, expected, message?)
+  assert.notEqual(actual, expected, message?)
+  assert.deepEqual(actual, expected, message?)
+  assert.match(value, regexp, message?)
+  assert.fail(message?)
+  cavemark.request(method, url, options?)
+                                      builds a fake request, options can set body, form and query
+  cavemark.main(request)              calls the function's main(namespace) with the request and
+                                      returns the response: status, headers, header(name), body,
+                                      json(), redirect and cookies
+
+Each test file gets its own empty database and mailbox.
+
+Examples:
+  # runs every test in "src"
+  cavemark test
+
+  # runs a single file and writes a JUnit report
+  cavemark test src/account.test.js --junit report.xml`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		p("cavemark", "version %s\n", cmd.Parent().Version)
+		files := args
+		if len(files) == 0 {
+			var err error
+			files, err = findTestFiles(funcDir)
+			if err != nil {
+				return fmt.Errorf("error finding tests: %w", err)
+			}
+		}
+		if len(files) == 0 {
+			p("test", "no test files found in '%s'\n", funcDir)
+			return nil
+		}
+
+		function, err := bundle()
+		if err != nil {
+			return err
+		}
+		suites := make([]*testSuite, 0, len(files))
+		failed := 0
+		for _, f := range files {
+			suite := runTestFile(f, function.code)
+			suites = append(suites, suite)
+			failed += suite.failures()
+		}
+
+		passed := 0
+		for _, suite := range suites {
+			passed += len(suite.cases) - suite.failures()
+		}
+		p("test", "%d passed, %d failed\n", passed, failed)
+		if testJUnit != "" {
+			err = writeJUnitReport(testJUnit, suites)
+			if err != nil {
+				return fmt.Errorf("error writing JUnit report: %w", err)
+			}
+			p("test", "wrote JUnit report to %s\n", testJUnit)
+		}
+		if failed > 0 {
+			return errors.New("tests failed")
+		}
+		return nil
+	},
+}
+
+type testSuite struct {
+	file     string
+	cases    []*testCase
+	err      error
+	duration time.Duration
+}
+
+type testCase struct {
+	name     string
+	fn       goja.Callable
+	failure  string
+	details  string
+	duration time.Duration
+}
+
+// failures counts the failed tests. A suite that couldn't run counts as one failure.
+func (s *testSuite) failures() int {
+	if s.err != nil {
+		return 1
+	}
+	n := 0
+	for _, c := range s.cases {
+		if c.failure != "" {
+			n++
+		}
+	}
+	return n
+}
+
+func findTestFiles(dir string) ([]string, error) {
+	files := make([]string, 0)
+	err := filepath.Walk(dir, func(path string, f os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if f.IsDir() {
+			if path != dir && (f.Name() == "node_modules" || strings.HasPrefix(f.Name(), ".")) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		for _, suffix := range testFileSuffixes {
+			if strings.HasSuffix(f.Name(), suffix) {
+				files = append(files, path)
+				break
+			}
+		}
+		return nil
+	})
+	sort.Strings(files)
+	return files, err
+}
+
+// runTestFile bundles and runs the tests in file. The function bundle runs in a runtime with a
+// temporary database and mailbox, so test files don't share state.
+func runTestFile(file string, function []byte) *testSuite {
+	start := time.Now()
+	suite := &testSuite{file: file}
+	defer func() { suite.duration = time.Since(start) }()
+	p("test", "%s\n", file)
+
+	rt, remove, err := newTempRuntime()
+	if err != nil {
+		suite.err = err
+		return suite
+	}
+	defer remove()
+	err = rt.load(function)
+	if err == nil {
+		err = rt.loadStaticRules()
+	}
+	if err != nil {
+		suite.err = err
+		p("error", "%s\n", err)
+		return suite
+	}
+
+	// test bundles aren't minified, so stack traces point at readable code
+	options, err := bundleOptions(file)
+	if err != nil {
+		suite.err = err
+		p("error", "%s\n", err)
+		return suite
+	}
+	options.MinifySyntax = false
+	options.MinifyWhitespace = false
+	result, err := buildBundle(options)
+	if err != nil {
+		suite.err = err
+		p("error", "%s\n", err)
+		return suite
+	}
+	program, err := goja.Compile(file, string(result.code), false)
+	if err != nil {
+		suite.err = fmt.Errorf("error compiling tests: %w", err)
+		p("error", "%s\n", suite.err)
+		return suite
+	}
+
+	call := &runtimeCall{rt: rt, vm: goja.New()}
+	_ = call.vm.Set("console", call.newConsole())
+	_ = call.vm.Set("test", func(name string, fn goja.Value) {
+		callable, ok := goja.AssertFunction(fn)
+		if !ok {
+			panic(call.vm.NewTypeError("test (%s) expects a function", name))
+		}
+		suite.cases = append(suite.cases, &testCase{name: name, fn: callable})
+	})
+	_ = call.vm.Set("assert", call.newAssert())
+	_ = call.vm.Set("cavemark", call.newTestHelpers())
+	_, err = call.vm.RunProgram(program)
+	if err != nil {
+		suite.err = err
+		p("error", "%s\n", err)
+		return suite
+	}
+
+	for _, c := range suite.cases {
+		c.run()
+		if c.failure != "" {
+			p("fail", "%s (%s)\n", c.name, c.duration.Round(time.Millisecond))
+			p("", "%s\n", indent(c.details, "            "))
+		} else {
+			p("pass", "%s (%s)\n", c.name, c.duration.Round(time.Millisecond))
+		}
+	}
+	return suite
+}
+
+func (c *testCase) run() {
+	start := time.Now()
+	defer func() { c.duration = time.Since(start) }()
+	value, err := c.fn(goja.Undefined())
+	if err != nil {
+		c.fail(err)
+		return
+	}
+	promise, ok := value.Export().(*goja.Promise)
+	if !ok {
+		return
+	}
+	switch promise.State() {
+	case goja.PromiseStateRejected:
+		c.failure = promise.Result().String()
+		c.details = exceptionDetails(promise.Result())
+	case goja.PromiseStatePending:
+		c.failure = "the test's promise never settled"
+		c.details = c.failure
+	}
+}
+
+func (c *testCase) fail(err error) {
+	var exception *goja.Exception
+	if errors.As(err, &exception) && exception.Value() != nil {
+		c.failure = exception.Value().String()
+		c.details = strings.TrimSpace(exception.String())
+		return
+	}
+	c.failure = err.Error()
+	c.details = err.Error()
+}
+
+// exceptionDetails returns the stack of an error value, or the value itself.
+func exceptionDetails(value goja.Value) string {
+	if o, ok := value.(*goja.Object); ok {
+		if stack := o.Get("stack"); stack != nil && !goja.IsUndefined(stack) {
+			return strings.TrimSpace(stack.String())
+		}
+	}
+	return value.String()
+}
+
+func indent(s, prefix string) string {
+	return prefix + strings.ReplaceAll(s, "\n", "\n"+prefix)
+}
+
+func (c *runtimeCall) newAssert() *goja.Object {
+	assert := c.vm.NewObject()
+	fail := func(message goja.Value, defaultMessage string, args ...interface{}) {
+		msg := fmt.Sprintf(defaultMessage, args...)
+		if message != nil && !goja.IsUndefined(message) {
+			msg = message.String()
+		}
+		err, _ := c.vm.New(c.vm.Get("Error"), c.vm.ToValue(msg))
+		_ = err.Set("name", "AssertionError")
+		panic(err)
+	}
+	inspect := func(value goja.Value) string {
+		if s, ok := value.Export().(string); ok {
+			return fmt.Sprintf("%q", s)
+		}
+		return c.stringify(value)
+	}
+	_ = assert.Set("ok", func(value, message goja.Value) {
+		if !value.ToBoolean() {
+			fail(message, "expected %s to be truthy", inspect(value))
+		}
+	})
+	_ = assert.Set("equal", func(actual, expected, message goja.Value) {
+		if !actual.StrictEquals(expected) {
+			fail(message, "expected %s to equal %s", inspect(actual), inspect(expected))
+		}
+	})
+	_ = assert.Set("notEqual", func(actual, expected, message goja.Value) {
+		if actual.StrictEquals(expected) {
+			fail(message, "expected %s not to equal %s", inspect(actual), inspect(expected))
+		}
+	})
+	_ = assert.Set("deepEqual", func(actual, expected, message goja.Value) {
+		if c.stringify(actual) != c.stringify(expected) {
+			fail(message, "expected %s to deeply equal %s", inspect(actual), inspect(expected))
+		}
+	})
+	_ = assert.Set("match", func(value, regexp, message goja.Value) {
+		test, ok := goja.AssertFunction(regexp.ToObject(c.vm).Get("test"))
+		if !ok {
+			panic(c.vm.NewTypeError("assert.match expects a regular expression"))
+		}
+		matched, err := test(regexp, value)
+		if err != nil {
+			panic(err)
+		}
+		if !matched.ToBoolean() {
+			fail(message, "expected %s to match %s", inspect(value), regexp.String())
+		}
+	})
+	_ = assert.Set("fail", func(message goja.Value) {
+		fail(message, "failed")
+	})
+	return assert
+}
+
+// stringify returns the JSON of a value, the same way setBody serializes response bodies.
+func (c *runtimeCall) stringify(value goja.Value) string {
+	stringify, _ := goja.AssertFunction(c.vm.Get("JSON").ToObject(c.vm).Get("stringify"))
+	s, err := stringify(goja.Undefined(), value)
+	if err != nil {
+		panic(err)
+	}
+	if goja.IsUndefined(s) {
+		return "undefined"
+	}
+	return s.String()
+}
+
+func (c *runtimeCall) newTestHelpers() *goja.Object {
+	helpers := c.vm.NewObject()
+	_ = helpers.Set("request", func(method, rawURL string, options goja.Value) *goja.Object {
+		request := c.vm.NewObject()
+		_ = request.Set("method", strings.ToUpper(method))
+		_ = request.Set("url", rawURL)
+		if options != nil && !goja.IsUndefined(options) && !goja.IsNull(options) {
+			o := options.ToObject(c.vm)
+			for _, key := range []string{"body", "form", "query"} {
+				if value := o.Get(key); value != nil {
+					_ = request.Set(key, value)
+				}
+			}
+		}
+		return request
+	})
+	_ = helpers.Set("main", func(request *goja.Object) *goja.Object {
+		req, err := c.testRequest(request)
+		if err != nil {
+			panic(c.vm.NewGoError(err))
+		}
+		res, err := c.rt.run(req)
+		if err != nil {
+			panic(c.vm.NewGoError(err))
+		}
+		return c.testResponse(res)
+	})
+	return helpers
+}
+
+// testRequest converts a request built with cavemark.request into a runtime request.
+func (c *runtimeCall) testRequest(request *goja.Object) (*runtimeRequest, error) {
+	u, err := neturl.Parse(request.Get("url").String())
+	if err != nil {
+		return nil, err
+	}
+	if u.Host == "" {
+		u.Scheme = "http"
+		u.Host = "localhost"
+	}
+	if u.Path == "" {
+		u.Path = "/"
+	}
+	req := &runtimeRequest{
+		method:  request.Get("method").String(),
+		path:    u.Path,
+		fullURL: u.String(),
+		form:    make(map[string][]string),
+		query:   u.Query(),
+	}
+	if body := request.Get("body"); body != nil && !goja.IsUndefined(body) {
+		if s, ok := body.Export().(string); ok {
+			req.body = s
+		} else {
+			req.body = c.stringify(body)
+		}
+	}
+	for key, values := range c.exportValues(request.Get("form")) {
+		req.form[key] = values
+	}
+	for key, values := range c.exportValues(request.Get("query")) {
+		req.query[key] = values
+	}
+	return req, nil
+}
+
+// exportValues converts an object of strings or string arrays into form or query values.
+func (c *runtimeCall) exportValues(value goja.Value) map[string][]string {
+	values := make(map[string][]string)
+	if value == nil || goja.IsUndefined(value) || goja.IsNull(value) {
+		return values
+	}
+	o := value.ToObject(c.vm)
+	for _, key := range o.Keys() {
+		v := o.Get(key)
+		if array, ok := v.Export().([]interface{}); ok {
+			for _, item := range array {
+				values[key] = append(values[key], fmt.Sprint(item))
+			}
+			continue
+		}
+		values[key] = append(values[key], v.String())
+	}
+	return values
+}
+
+// testResponse converts a captured response into the object returned by cavemark.main.
+func (c *runtimeCall) testResponse(res *runtimeResponse) *goja.Object {
+	response := c.vm.NewObject()
+	_ = response.Set("status", res.status)
+	_ = response.Set("body", string(res.body))
+	headers := c.vm.NewObject()
+	for key, values := range res.headers {
+		_ = headers.Set(strings.ToLower(key), strings.Join(values, ", "))
+	}
+	_ = response.Set("headers", headers)
+	_ = response.Set("header", func(name string) goja.Value {
+		values, ok := res.headers[http.CanonicalHeaderKey(name)]
+		if !ok {
+			return goja.Null()
+		}
+		return c.vm.ToValue(strings.Join(values, ", "))
+	})
+	_ = response.Set("json", func() goja.Value {
+		parse, _ := goja.AssertFunction(c.vm.Get("JSON").ToObject(c.vm).Get("parse"))
+		value, err := parse(goja.Undefined(), c.vm.ToValue(string(res.body)))
+		if err != nil {
+			panic(err)
+		}
+		return value
+	})
+	redirect := goja.Null()
+	if location := res.headers.Get("Location"); location != "" && res.status >= 300 && res.status < 400 {
+		redirect = c.vm.ToValue(location)
+	}
+	_ = response.Set("redirect", redirect)
+	cookies := c.vm.NewObject()
+	for _, cookie := range (&http.Response{Header: res.headers}).Cookies() {
+		o := c.vm.NewObject()
+		_ = o.Set("value", cookie.Value)
+		_ = o.Set("path", cookie.Path)
+		_ = o.Set("maxAge", cookie.MaxAge)
+		_ = o.Set("httpOnly", cookie.HttpOnly)
+		_ = o.Set("secure", cookie.Secure)
+		_ = cookies.Set(cookie.Name, o)
+	}
+	_ = response.Set("cookies", cookies)
+	return response
+}
+
+type junitTestSuites struct {
+	XMLName  xml.Name         `xml:"testsuites"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Suites   []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name     string          `xml:"name,attr"`
+	Tests    int             `xml:"tests,attr"`
+	Failures int             `xml:"failures,attr"`
+	Errors   int             `xml:"errors,attr"`
+	Time     string          `xml:"time,attr"`
+	Cases    []junitTestCase `xml:"testcase"`
+	Error    *junitFailure   `xml:"error,omitempty"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+func writeJUnitReport(file string, suites []*testSuite) error {
+	report := junitTestSuites{}
+	for _, suite := range suites {
+		s := junitTestSuite{
+			Name:     filepath.ToSlash(suite.file),
+			Tests:    len(suite.cases),
+			Failures: suite.failures(),
+			Time:     junitTime(suite.duration),
+		}
+		if suite.err != nil {
+			s.Failures = 0
+			s.Errors = 1
+			s.Error = &junitFailure{Message: suite.err.Error(), Text: suite.err.Error()}
+		}
+		for _, c := range suite.cases {
+			tc := junitTestCase{Name: c.name, ClassName: s.Name, Time: junitTime(c.duration)}
+			if c.failure != "" {
+				tc.Failure = &junitFailure{Message: c.failure, Text: c.details}
+			}
+			s.Cases = append(s.Cases, tc)
+		}
+		report.Tests += s.Tests
+		report.Failures += s.Failures
+		report.Suites = append(report.Suites, s)
+	}
+	data, err := xml.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(file, append([]byte(xml.Header), append(data, '\n')...), 0644)
+}
+
+func junitTime(d time.Duration) string {
+	return fmt.Sprintf("%.3f", d.Seconds())
+}
+
+func init() {
+	testCmd.Flags().StringVarP(&testJUnit, "junit", "", "", fmt.Sprintf("write the results as a JUnit report to this file [%s]", cavemarkTestJUnit))
+	addProjectDirFlags(testCmd.Flags())
+	addBundleFlags(testCmd.Flags())
+	addStaticRuleFlags(testCmd.Flags())
+	rootCmd.AddCommand(testCmd)
+
+	testJUnit = resolveStringFlag(testJUnit, cavemarkTestJUnit, "")
+}
@@ -0,0 +1,79 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	typecheck bool
+)
+
+const (
+	cavemarkTypecheck = "CAVEMARK_TYPECHECK"
+)
+
+var typecheckCmd = &cobra.Command{
+	Use:   "typecheck",
+	Short: "type check a TypeScript project",
+	Long: `Type checks a TypeScript Cavemark project using the TypeScript compiler (tsc).
+
+TypeScript must be installed in the project (npm install --save-dev typescript) or be
+available on the PATH. The project's tsconfig.json is used.
+
+Example:
+  # type checks the project in the current directory
+  cavemark typecheck`,
+	Args: cobra.MaximumNArgs(0),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		p("cavemark", "version %s\n", cmd.Parent().Version)
+		return runTypecheck()
+	},
+}
+
+func runTypecheck() error {
+	tscPath, err := findTsc()
+	if err != nil {
+		return err
+	}
+	p("typecheck", "running %s\n", tscPath)
+	tsc := exec.Command(tscPath, "--noEmit")
+	tsc.Stdout = os.Stdout
+	tsc.Stderr = os.Stderr
+	err = tsc.Run()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return fmt.Errorf("type check failed")
+		}
+		return fmt.Errorf("error running tsc: %w", err)
+	}
+	p("typecheck", "no type errors found\n")
+	return nil
+}
+
+func findTsc() (string, error) {
+	local := filepath.Join("node_modules", ".bin", "tsc")
+	if runtime.GOOS == "windows" {
+		local += ".cmd"
+	}
+	_, err := os.Stat(local)
+	if err == nil {
+		return local, nil
+	}
+	tscPath, err := exec.LookPath("tsc")
+	if err != nil {
+		return "", errors.New("tsc not found, please run: npm install --save-dev typescript")
+	}
+	return tscPath, nil
+}
+
+func init() {
+	rootCmd.AddCommand(typecheckCmd)
+}
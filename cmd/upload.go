@@ -0,0 +1,286 @@
+package cmd
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/pflag"
+)
+
+var (
+	uploadChunkSize string
+)
+
+const (
+	cavemarkUploadChunkSize = "CAVEMARK_UPLOAD_CHUNK_SIZE"
+)
+
+// uploadRetries is the number of times a chunk is sent again after a network or server error.
+const uploadRetries = 3
+
+// sniffLength is the number of bytes http.DetectContentType looks at.
+const sniffLength = 512
+
+// uploadBody is the contents of a file to upload. Files are streamed from disk instead of being
+// read into memory, and are opened again at an offset to resume a chunked upload.
+type uploadBody struct {
+	open func(offset int64) (io.ReadCloser, error)
+	size int64
+	// id identifies the contents, so an interrupted upload of the same contents can be resumed
+	id string
+}
+
+func bytesBody(contents []byte) uploadBody {
+	hash := sha256.Sum256(contents)
+	return uploadBody{
+		open: func(offset int64) (io.ReadCloser, error) {
+			return ioutil.NopCloser(bytes.NewReader(contents[offset:])), nil
+		},
+		size: int64(len(contents)),
+		id:   hex.EncodeToString(hash[:]),
+	}
+}
+
+func fileBody(f string) (uploadBody, error) {
+	info, err := os.Stat(f)
+	if err != nil {
+		return uploadBody{}, fmt.Errorf("error reading file (%s): %w", f, err)
+	}
+	abs, err := filepath.Abs(f)
+	if err != nil {
+		return uploadBody{}, err
+	}
+	return uploadBody{
+		open: func(offset int64) (io.ReadCloser, error) {
+			file, err := os.Open(f)
+			if err != nil {
+				return nil, fmt.Errorf("error reading file (%s): %w", f, err)
+			}
+			_, err = file.Seek(offset, io.SeekStart)
+			if err != nil {
+				_ = file.Close()
+				return nil, err
+			}
+			return file, nil
+		},
+		size: info.Size(),
+		id:   fmt.Sprintf("%s:%d:%d", abs, info.Size(), info.ModTime().UnixNano()),
+	}, nil
+}
+
+// readAll reads the body into memory, for the files whose contents are rewritten.
+func (b uploadBody) readAll() ([]byte, error) {
+	rc, err := b.open(0)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rc.Close() }()
+	return io.ReadAll(rc)
+}
+
+// sniffContentType returns the content type of a file, reading at most the first 512 bytes.
+func sniffContentType(f string) (string, error) {
+	file, err := os.Open(f)
+	if err != nil {
+		return "", fmt.Errorf("error reading file (%s): %w", f, err)
+	}
+	defer func() { _ = file.Close() }()
+	head := make([]byte, sniffLength)
+	n, err := io.ReadFull(file, head)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return "", fmt.Errorf("error reading file (%s): %w", f, err)
+	}
+	return contentTypeOf(f, head[:n])
+}
+
+// upload sends a body to target, in chunks when it is larger than --upload-chunk-size and the
+// server supports chunked uploads, and returns the status of the last response. Chunks are never
+// larger than the maximum request size of the server.
+func upload(target, contentType string, headers http.Header, body uploadBody) (int, error) {
+	chunkSize, err := parseUploadChunkSize()
+	if err != nil {
+		return 0, err
+	}
+	maxRequestSize := server.Limits.MaxRequestSize
+	if maxRequestSize > 0 && chunkSize > maxRequestSize {
+		chunkSize = maxRequestSize
+	}
+	if body.size > chunkSize && server.supports(featureChunkedUpload) {
+		return uploadChunks(target, contentType, headers, body, chunkSize)
+	}
+	if maxRequestSize > 0 && body.size > maxRequestSize {
+		return 0, fmt.Errorf("%s is larger than the %s the server accepts in one request and the server doesn't support chunked uploads", formatByteSize(body.size), formatByteSize(maxRequestSize))
+	}
+	return uploadWhole(target, contentType, headers, body)
+}
+
+// uploadWhole sends the body in a single request with a known Content-Length and returns the
+// status of the response.
+func uploadWhole(target, contentType string, headers http.Header, body uploadBody) (int, error) {
+	rc, err := body.open(0)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = rc.Close() }()
+	resp, err := httpCallWithLength(http.MethodPut, target, contentType, headers, rc, body.size)
+	if err != nil {
+		return 0, err
+	}
+	_ = resp.Body.Close()
+	return resp.StatusCode, nil
+}
+
+// uploadChunks sends the body in chunks with a Content-Range header, the same way resumable
+// uploads to cloud storage work: until the last chunk the server responds with 308 and the Range
+// it has received. An Upload-Id header identifies the upload. Before the first chunk and after
+// an error, an empty request with "Content-Range: bytes */size" asks the server how much it has
+// received, so an upload resumes where it stopped, even when the deployment is run again. The
+// server responds with 204 and "Range: bytes=0-(size-1)" when it already has the whole file.
+func uploadChunks(target, contentType string, headers http.Header, body uploadBody, chunkSize int64) (int, error) {
+	uploadID := sha256.Sum256([]byte(target + "\n" + body.id))
+	headers = headers.Clone()
+	if headers == nil {
+		headers = make(http.Header)
+	}
+	headers.Set("Upload-Id", hex.EncodeToString(uploadID[:16]))
+
+	progress := &uploadProgress{size: body.size}
+	offset, status, err := uploadOffset(target, contentType, headers, body.size)
+	if err != nil || status != http.StatusPermanentRedirect {
+		return status, err
+	}
+	if offset > 0 {
+		p("", " resuming at %s", formatByteSize(offset))
+	}
+	retries := 0
+	for offset < body.size {
+		end := offset + chunkSize
+		if end > body.size {
+			end = body.size
+		}
+		next, status, err := uploadChunk(target, contentType, headers, body, offset, end)
+		if err != nil || status >= http.StatusInternalServerError {
+			retries++
+			if retries > uploadRetries {
+				return status, err
+			}
+			p("", " [retry]")
+			offset, status, err = uploadOffset(target, contentType, headers, body.size)
+			if err != nil || status != http.StatusPermanentRedirect {
+				return status, err
+			}
+			continue
+		}
+		retries = 0
+		if status != http.StatusPermanentRedirect {
+			if status == http.StatusNoContent && end < body.size {
+				return 0, fmt.Errorf("upload completed after %s of %s", formatByteSize(end), formatByteSize(body.size))
+			}
+			progress.report(end)
+			return status, nil
+		}
+		offset = next
+		progress.report(offset)
+	}
+	return 0, errors.New("upload did not complete after the last chunk")
+}
+
+// uploadOffset asks the server how many bytes of the upload it has received. It returns 308 while
+// the upload is incomplete and 204 when the server already has the whole file. A 204 that doesn't
+// confirm the whole file fails, since the server could have stored the empty request as the file.
+func uploadOffset(target, contentType string, headers http.Header, size int64) (int64, int, error) {
+	headers = headers.Clone()
+	headers.Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+	resp, err := httpCallWithLength(http.MethodPut, target, contentType, headers, http.NoBody, 0)
+	if err != nil {
+		return 0, 0, err
+	}
+	_ = resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusNotFound:
+		// the server doesn't know the upload yet
+		return 0, http.StatusPermanentRedirect, nil
+	case http.StatusPermanentRedirect:
+		return receivedRange(resp, 0), resp.StatusCode, nil
+	case http.StatusNoContent:
+		if resp.Header.Get("Range") != fmt.Sprintf("bytes=0-%d", size-1) {
+			return 0, 0, fmt.Errorf("the server reported the upload as complete without confirming it has all %d bytes", size)
+		}
+		return size, resp.StatusCode, nil
+	}
+	return 0, resp.StatusCode, nil
+}
+
+// uploadChunk sends the bytes from offset up to end and returns the offset to continue at.
+func uploadChunk(target, contentType string, headers http.Header, body uploadBody, offset, end int64) (int64, int, error) {
+	rc, err := body.open(offset)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer func() { _ = rc.Close() }()
+	headers = headers.Clone()
+	headers.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", offset, end-1, body.size))
+	resp, err := httpCallWithLength(http.MethodPut, target, contentType, headers, io.LimitReader(rc, end-offset), end-offset)
+	if err != nil {
+		return 0, 0, err
+	}
+	_ = resp.Body.Close()
+	return receivedRange(resp, end), resp.StatusCode, nil
+}
+
+// receivedRange returns the offset after the Range header of a 308 response, e.g. 100 for
+// "bytes=0-99", or fallback without a Range header.
+func receivedRange(resp *http.Response, fallback int64) int64 {
+	r := strings.TrimPrefix(resp.Header.Get("Range"), "bytes=")
+	pair := strings.SplitN(r, "-", 2)
+	if len(pair) != 2 {
+		return fallback
+	}
+	last, err := strconv.ParseInt(pair[1], 10, 64)
+	if err != nil {
+		return fallback
+	}
+	return last + 1
+}
+
+// uploadProgress prints the progress of a chunked upload in steps of 10%.
+type uploadProgress struct {
+	size    int64
+	printed int64
+}
+
+func (u *uploadProgress) report(offset int64) {
+	percent := offset * 100 / u.size / 10 * 10
+	if percent <= u.printed {
+		return
+	}
+	u.printed = percent
+	p("", " %d%%", percent)
+}
+
+// parseUploadChunkSize returns the size above which files are uploaded in chunks.
+func parseUploadChunkSize() (int64, error) {
+	chunkSize, err := parseByteSize(uploadChunkSize)
+	if err != nil {
+		return 0, err
+	}
+	if chunkSize <= 0 {
+		return 0, fmt.Errorf("upload chunk size (%s) must be larger than 0", uploadChunkSize)
+	}
+	return chunkSize, nil
+}
+
+func addUploadFlags(flags *pflag.FlagSet) {
+	flags.StringVarP(&uploadChunkSize, "upload-chunk-size", "", "", fmt.Sprintf("upload files larger than this size in resumable chunks of this size, e.g. 8MB [%s]", cavemarkUploadChunkSize))
+	uploadChunkSize = resolveStringFlag(uploadChunkSize, cavemarkUploadChunkSize, "8MB")
+}
@@ -0,0 +1,160 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"regexp"
+	"strconv"
+	"testing"
+)
+
+// chunkServer is a server that accepts chunked uploads and records the Content-Range headers of
+// the requests.
+type chunkServer struct {
+	received []byte
+	// accept is the maximum number of bytes of a chunk the server keeps, 0 keeps all of them
+	accept int
+	// completeRange is the Range header of a 204 response to a query of a completed upload
+	completeRange string
+	ranges        []string
+}
+
+var chunkRangePattern = regexp.MustCompile(`^bytes (\d+)-(\d+)/(\d+)$`)
+
+func (s *chunkServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	cr := r.Header.Get("Content-Range")
+	s.ranges = append(s.ranges, cr)
+	body, _ := io.ReadAll(r.Body)
+	if r.Header.Get("Upload-Id") == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	m := chunkRangePattern.FindStringSubmatch(cr)
+	if m == nil {
+		// a query of how much has been received
+		total, _ := strconv.Atoi(cr[len("bytes */"):])
+		switch {
+		case len(s.received) == total:
+			if s.completeRange != "" {
+				w.Header().Set("Range", s.completeRange)
+			}
+			w.WriteHeader(http.StatusNoContent)
+		case len(s.received) == 0:
+			w.WriteHeader(http.StatusNotFound)
+		default:
+			w.Header().Set("Range", fmt.Sprintf("bytes=0-%d", len(s.received)-1))
+			w.WriteHeader(http.StatusPermanentRedirect)
+		}
+		return
+	}
+	start, _ := strconv.Atoi(m[1])
+	total, _ := strconv.Atoi(m[3])
+	if start != len(s.received) {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if s.accept > 0 && len(body) > s.accept {
+		body = body[:s.accept]
+	}
+	s.received = append(s.received, body...)
+	if len(s.received) == total {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	w.Header().Set("Range", fmt.Sprintf("bytes=0-%d", len(s.received)-1))
+	w.WriteHeader(http.StatusPermanentRedirect)
+}
+
+func TestUploadChunks(t *testing.T) {
+	contents := []byte("0123456789abcdefghijklmnopqrstuvwxy")
+	tests := []struct {
+		name       string
+		server     *chunkServer
+		wantRanges []string
+		wantErr    string
+	}{
+		{
+			name:       "new upload",
+			server:     &chunkServer{},
+			wantRanges: []string{"bytes */35", "bytes 0-9/35", "bytes 10-19/35", "bytes 20-29/35", "bytes 30-34/35"},
+		},
+		{
+			name:       "resumed upload",
+			server:     &chunkServer{received: contents[:12]},
+			wantRanges: []string{"bytes */35", "bytes 12-21/35", "bytes 22-31/35", "bytes 32-34/35"},
+		},
+		{
+			name:       "server keeps part of a chunk",
+			server:     &chunkServer{accept: 6},
+			wantRanges: []string{"bytes */35", "bytes 0-9/35", "bytes 6-15/35", "bytes 12-21/35", "bytes 18-27/35", "bytes 24-33/35", "bytes 30-34/35"},
+		},
+		{
+			name:       "completed upload",
+			server:     &chunkServer{received: contents, completeRange: "bytes=0-34"},
+			wantRanges: []string{"bytes */35"},
+		},
+		{
+			name:       "completed upload without a Range",
+			server:     &chunkServer{received: contents},
+			wantRanges: []string{"bytes */35"},
+			wantErr:    "the server reported the upload as complete without confirming it has all 35 bytes",
+		},
+		{
+			name:       "completed upload with a shorter Range",
+			server:     &chunkServer{received: contents, completeRange: "bytes=0-9"},
+			wantRanges: []string{"bytes */35"},
+			wantErr:    "the server reported the upload as complete without confirming it has all 35 bytes",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := httptest.NewServer(tt.server)
+			defer ts.Close()
+			status, err := uploadChunks(ts.URL, "application/octet-stream", nil, bytesBody(contents), 10)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("uploadChunks() error = %v, want %s", err, tt.wantErr)
+				}
+			} else {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if status != http.StatusNoContent {
+					t.Errorf("uploadChunks() status = %d, want 204", status)
+				}
+				if string(tt.server.received) != string(contents) {
+					t.Errorf("server received %q, want %q", tt.server.received, contents)
+				}
+			}
+			if !reflect.DeepEqual(tt.server.ranges, tt.wantRanges) {
+				t.Errorf("Content-Range headers = %q, want %q", tt.server.ranges, tt.wantRanges)
+			}
+		})
+	}
+}
+
+func TestReceivedRange(t *testing.T) {
+	tests := []struct {
+		header string
+		want   int64
+	}{
+		{"bytes=0-99", 100},
+		{"bytes=0-0", 1},
+		{"", 42},
+		{"bytes=0-x", 42},
+	}
+	for _, tt := range tests {
+		t.Run(tt.header, func(t *testing.T) {
+			resp := &http.Response{Header: http.Header{}}
+			if tt.header != "" {
+				resp.Header.Set("Range", tt.header)
+			}
+			if got := receivedRange(resp, 42); got != tt.want {
+				t.Errorf("receivedRange(%s) = %d, want %d", tt.header, got, tt.want)
+			}
+		})
+	}
+}